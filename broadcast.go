@@ -4,46 +4,146 @@ import (
 	"sync"
 )
 
+// OverflowPolicy decides what happens when a message is broadcast to a
+// listener whose buffer is full.
+type OverflowPolicy int
+
+const (
+	// DropOldest discards the oldest buffered message to make room for the
+	// new one.
+	DropOldest OverflowPolicy = iota
+	// DropNewest discards the new message.
+	DropNewest
+	// Disconnect removes the listener and closes its channel.
+	Disconnect
+)
+
+// defaultBufferSize is the per-listener buffer size used by NewBroadcaster.
+const defaultBufferSize = 16
+
 // Broadcaster is a generic type that allows broadcasting messages of type T
 // to multiple listeners.
+//
+// Every listener has its own bounded buffer. Messages are delivered to a
+// listener in the order they were broadcast. Broadcast never blocks; when a
+// listener falls behind its buffer fills up and the OverflowPolicy is applied.
 type Broadcaster[T any] struct {
-	listeners map[*chan T]struct{}
-	mu        sync.RWMutex
+	listeners map[*chan T]func(T) bool
+	mu        sync.Mutex
+	size      int
+	policy    OverflowPolicy
+	closed    bool
 }
 
-// NewBroadcaster creates and returns a new Broadcaster for type T.
+// NewBroadcaster creates and returns a new Broadcaster for type T that drops
+// the oldest message for listeners that fall behind.
 func NewBroadcaster[T any]() *Broadcaster[T] {
+	return NewBufferedBroadcaster[T](defaultBufferSize, DropOldest)
+}
+
+// NewBufferedBroadcaster creates and returns a new Broadcaster for type T.
+// Each listener buffers up to size messages, policy is applied when the
+// buffer is full.
+func NewBufferedBroadcaster[T any](size int, policy OverflowPolicy) *Broadcaster[T] {
+	if size < 1 {
+		size = 1
+	}
 	return &Broadcaster[T]{
-		listeners: make(map[*chan T]struct{}),
+		listeners: make(map[*chan T]func(T) bool),
+		size:      size,
+		policy:    policy,
 	}
 }
 
 // AddListener creates a new channel for receiving broadcast messages and
 // registers it with the Broadcaster. It returns the newly created receive-only channel
-// and a function to remove the listener.
+// and a function to remove the listener. The channel is closed when the
+// listener is removed. It is safe to call the remove function multiple times.
 func (b *Broadcaster[T]) AddListener() (<-chan T, func()) {
+	return b.AddFilteredListener(nil)
+}
+
+// AddFilteredListener works like AddListener but the listener only receives
+// messages for which filter returns true. A nil filter accepts every message.
+// filter is called while the Broadcaster is locked, it must not call
+// methods of the Broadcaster.
+func (b *Broadcaster[T]) AddFilteredListener(filter func(T) bool) (<-chan T, func()) {
 	b.mu.Lock()
 	defer b.mu.Unlock()
-	ch := make(chan T)
-	b.listeners[&ch] = struct{}{}
+	ch := make(chan T, b.size)
+	if b.closed {
+		close(ch)
+		return ch, func() {}
+	}
+	b.listeners[&ch] = filter
 
 	return ch, func() {
 		b.mu.Lock()
 		defer b.mu.Unlock()
-		delete(b.listeners, &ch)
-		close(ch)
+		b.remove(&ch)
+	}
+}
+
+// remove unregisters and closes ch if it is still registered. b.mu must be
+// held.
+func (b *Broadcaster[T]) remove(ch *chan T) {
+	if _, ok := b.listeners[ch]; !ok {
+		return
 	}
+	delete(b.listeners, ch)
+	close(*ch)
+}
+
+// Close removes every listener and closes their channels. Messages already
+// buffered can still be received. Listeners added after Close receive a
+// closed channel.
+func (b *Broadcaster[T]) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	for ch := range b.listeners {
+		b.remove(ch)
+	}
+}
+
+// Len returns the number of registered listeners.
+func (b *Broadcaster[T]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.listeners)
 }
 
 // Broadcast sends the given message to all registered listeners.
-// This operation is non-blocking for the broadcaster, but may block
-// if any listener's channel is full.
+// This operation never blocks. Listeners with a full buffer are handled
+// according to the Broadcaster's OverflowPolicy.
 func (b *Broadcaster[T]) Broadcast(message T) {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	for ch := range b.listeners {
-		go func(c *chan T) {
-			*c <- message
-		}(ch)
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch, filter := range b.listeners {
+		if filter != nil && !filter(message) {
+			continue
+		}
+
+		select {
+		case *ch <- message:
+			continue
+		default:
+		}
+
+		switch b.policy {
+		case DropOldest:
+			// Only Broadcast sends on ch and it holds b.mu, so after
+			// taking a message out there is room for the new one. The
+			// listener may have drained the buffer in the meantime which
+			// is fine too.
+			select {
+			case <-*ch:
+			default:
+			}
+			*ch <- message
+		case DropNewest:
+		case Disconnect:
+			b.remove(ch)
+		}
 	}
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"reflect"
 	"sync"
 	"testing"
 	"time"
@@ -129,3 +130,195 @@ func TestBroadcaster(t *testing.T) {
 		}
 	})
 }
+
+func TestBroadcaster_OverflowPolicy(t *testing.T) {
+	drain := func(ch <-chan int) []int {
+		var got []int
+		for {
+			select {
+			case msg, ok := <-ch:
+				if !ok {
+					return got
+				}
+				got = append(got, msg)
+			default:
+				return got
+			}
+		}
+	}
+
+	tests := []struct {
+		name   string
+		policy OverflowPolicy
+		want   []int
+		closed bool
+	}{
+		{"DropOldest", DropOldest, []int{3, 4, 5}, false},
+		{"DropNewest", DropNewest, []int{1, 2, 3}, false},
+		{"Disconnect", Disconnect, []int{1, 2, 3}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBufferedBroadcaster[int](3, tt.policy)
+			ch, remove := b.AddListener()
+			defer remove()
+
+			for i := 1; i <= 5; i++ {
+				b.Broadcast(i)
+			}
+
+			got := drain(ch)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected messages\nwant: %v\ngot:  %v", tt.want, got)
+			}
+
+			var closed bool
+			select {
+			case _, ok := <-ch:
+				closed = !ok
+			default:
+			}
+			if closed != tt.closed {
+				t.Errorf("unexpected channel state\nwant closed: %t\ngot closed:  %t", tt.closed, closed)
+			}
+			if tt.closed && len(b.listeners) != 0 {
+				t.Errorf("Expected 0 listeners after disconnect, got %d", len(b.listeners))
+			}
+		})
+	}
+}
+
+func TestBroadcaster_RemoveTwice(t *testing.T) {
+	b := NewBroadcaster[int]()
+	_, remove := b.AddListener()
+	remove()
+	remove()
+}
+
+func TestBroadcaster_Close(t *testing.T) {
+	b := NewBroadcaster[int]()
+	ch, remove := b.AddListener()
+	b.Broadcast(1)
+	b.Close()
+	remove()
+
+	if msg, ok := <-ch; !ok || msg != 1 {
+		t.Errorf("Expected buffered message 1, got %d (ok=%t)", msg, ok)
+	}
+	if _, ok := <-ch; ok {
+		t.Error("Channel should be closed after Close")
+	}
+
+	ch, _ = b.AddListener()
+	if _, ok := <-ch; ok {
+		t.Error("Listener added after Close should receive a closed channel")
+	}
+	if b.Len() != 0 {
+		t.Errorf("Expected 0 listeners after Close, got %d", b.Len())
+	}
+}
+
+func TestBroadcaster_Stress(t *testing.T) {
+	for _, policy := range []OverflowPolicy{DropOldest, DropNewest, Disconnect} {
+		b := NewBufferedBroadcaster[int](4, policy)
+		messageCount := 1000
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			// Register before broadcasting starts so every listener sees
+			// the last message.
+			ch, remove := b.AddListener()
+			wg.Add(1)
+			go func(slow bool) {
+				defer wg.Done()
+				defer remove()
+
+				// Messages must arrive in order, some may be dropped.
+				last := -1
+				for msg := range ch {
+					if msg <= last {
+						t.Errorf("policy %d: out of order delivery: %d after %d", policy, msg, last)
+						return
+					}
+					last = msg
+					if slow {
+						time.Sleep(time.Microsecond)
+					}
+					if msg == messageCount-1 {
+						return
+					}
+				}
+			}(i%2 == 0)
+		}
+
+		// Remove listeners concurrently with broadcasting.
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, remove := b.AddListener()
+				time.Sleep(time.Millisecond)
+				remove()
+			}()
+		}
+
+		for i := range messageCount {
+			b.Broadcast(i)
+		}
+		// Make sure the last message gets through for the DropNewest
+		// listeners that are still waiting for it.
+		for range 10 {
+			time.Sleep(10 * time.Millisecond)
+			b.Broadcast(messageCount - 1)
+		}
+
+		done := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("policy %d: listeners did not finish", policy)
+		}
+	}
+}
+
+func TestBroadcaster_FilteredListener(t *testing.T) {
+	b := NewBroadcaster[int]()
+	even, _ := b.AddFilteredListener(func(n int) bool { return n%2 == 0 })
+	all, _ := b.AddListener()
+
+	for i := 1; i <= 4; i++ {
+		b.Broadcast(i)
+	}
+
+	for _, tt := range []struct {
+		name string
+		ch   <-chan int
+		want []int
+	}{
+		{"even", even, []int{2, 4}},
+		{"all", all, []int{1, 2, 3, 4}},
+	} {
+		var got []int
+		for range tt.want {
+			select {
+			case msg := <-tt.ch:
+				got = append(got, msg)
+			case <-time.After(time.Second):
+				t.Fatalf("%s: Timeout waiting for message", tt.name)
+			}
+		}
+		select {
+		case msg := <-tt.ch:
+			t.Errorf("%s: unexpected message %d", tt.name, msg)
+		default:
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: unexpected messages\nwant: %v\ngot:  %v", tt.name, tt.want, got)
+		}
+	}
+}
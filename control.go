@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// controlPrefix is the URL prefix of the control API.
+const controlPrefix = "/_dev/api/"
+
+// controlHandler serves the control API used by the in-browser toolbar and
+// other tools.
+//
+//	GET  /_dev/api/status       current statusSnapshot
+//	POST /_dev/api/rebuild      rebuild and restart the server
+//	POST /_dev/api/restart      restart the server without building
+//	POST /_dev/api/reload       reload the connected browsers
+//	POST /_dev/api/live-reload  enable/disable live reload, {"enabled": bool}
+//	GET  /_dev/api/logs         recent log output, see serveLogs
+//	GET  /_dev/api/network      simulated netConditions
+//	POST /_dev/api/network      change the netConditions, see serveNetwork
+//
+// rebuild and restart return immediately with 202 Accepted. With the wait=1
+// query parameter they wait for the restart to finish and return 200 OK, or
+// 500 Internal Server Error if the build failed or the server did not come
+// up. All endpoints except logs and network respond with the statusSnapshot.
+type controlHandler struct {
+	restart       chan<- restartRequest
+	stream        *eventStream
+	status        *serverStatus
+	logs          *logMux
+	network       *networkSimulator
+	setLiveReload func(enabled bool)
+}
+
+func (h *controlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin request", http.StatusForbidden)
+		return
+	}
+
+	endpoint := strings.TrimPrefix(r.URL.Path, controlPrefix)
+	methods := []string{"POST"}
+	switch endpoint {
+	case "status", "logs":
+		methods = []string{"GET"}
+	case "network":
+		methods = []string{"GET", "POST"}
+	}
+	if !slices.Contains(methods, r.Method) {
+		w.Header().Set("allow", strings.Join(methods, ", "))
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch endpoint {
+	case "status":
+		writeJSON(w, http.StatusOK, h.status.Snapshot())
+	case "rebuild":
+		h.requestRestart(w, r, restartRequest{build: true})
+	case "restart":
+		h.requestRestart(w, r, restartRequest{build: false})
+	case "reload":
+		h.stream.Change(fsEventBatch{})
+		writeJSON(w, http.StatusOK, h.status.Snapshot())
+	case "live-reload":
+		var body struct {
+			Enabled *bool `json:"enabled"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil || body.Enabled == nil {
+			http.Error(w, `expected {"enabled": bool}`, http.StatusBadRequest)
+			return
+		}
+		h.setLiveReload(*body.Enabled)
+		writeJSON(w, http.StatusOK, h.status.Snapshot())
+	case "logs":
+		h.serveLogs(w, r)
+	case "network":
+		h.serveNetwork(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// logsResponse is the response of the logs endpoint. LastID can be used as
+// Last-Event-ID to follow the logs on the event stream.
+type logsResponse struct {
+	LastID  uint64     `json:"lastID"`
+	Entries []logEntry `json:"entries"`
+}
+
+// serveLogs responds with the lines kept by the logMux. The source query
+// parameter limits the lines to the given comma separated sources, lines to
+// the last n lines.
+func (h *controlHandler) serveLogs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var sources []string
+	for _, v := range q["source"] {
+		for s := range strings.SplitSeq(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				sources = append(sources, s)
+			}
+		}
+	}
+	var n int
+	if v := q.Get("lines"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n < 0 {
+			http.Error(w, "invalid lines", http.StatusBadRequest)
+			return
+		}
+	}
+
+	resp := logsResponse{Entries: []logEntry{}}
+	for _, l := range h.logs.Lines(n, sources...) {
+		resp.Entries = append(resp.Entries, l.logEntry)
+		resp.LastID = l.ID
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// serveNetwork responds with the simulated network conditions. A POST
+// request changes the fields present in the JSON body, e.g. {"enabled": false}
+// or {"latency": "500ms"}, the rest of the conditions are kept.
+func (h *controlHandler) serveNetwork(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		// Faults replace the current ones instead of being merged into
+		// them.
+		var body struct {
+			netConditions
+			Faults *[]netFault `json:"faults"`
+		}
+		body.netConditions = h.network.Conditions()
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			http.Error(w, fmt.Sprintf("invalid network conditions: %v", err), http.StatusBadRequest)
+			return
+		}
+		cond := body.netConditions
+		if body.Faults != nil {
+			cond.Faults = *body.Faults
+		}
+		if err := h.network.Set(cond); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, h.network.Conditions())
+}
+
+// requestRestart hands req over to rerun. It waits while another restart is
+// pending, e.g. when a build is in progress.
+func (h *controlHandler) requestRestart(w http.ResponseWriter, r *http.Request, req restartRequest) {
+	wait := r.URL.Query().Get("wait")
+	var done chan statusSnapshot
+	if wait != "" && wait != "0" && wait != "false" {
+		done = make(chan statusSnapshot, 1)
+		req.done = done
+	}
+
+	select {
+	case h.restart <- req:
+	case <-r.Context().Done():
+		return
+	}
+
+	if done == nil {
+		writeJSON(w, http.StatusAccepted, h.status.Snapshot())
+		return
+	}
+
+	select {
+	case st := <-done:
+		code := http.StatusOK
+		if st.State != stateIdle {
+			code = http.StatusInternalServerError
+		}
+		writeJSON(w, code, st)
+	case <-r.Context().Done():
+	}
+}
+
+// serveControlSocket serves the control API, the event stream and the request
+// log on a Unix domain socket at path until ctx is cancelled. A stale socket
+// file left behind by a previous run is removed.
+func serveControlSocket(ctx context.Context, path string, control, events, requests http.Handler) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("control: %w", err)
+	}
+
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return fmt.Errorf("control: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(controlPrefix, control)
+	mux.Handle("/_dev", events)
+	mux.Handle(requestsPath+"/", requests)
+	srv := http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 1 * time.Minute,
+	}
+
+	go func() {
+		<-ctx.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+
+	infof("Control API listening on %s", path)
+	if err := srv.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// sameOrigin reports whether r was sent by a page served by devserver or by a
+// non-browser client. It keeps other websites from driving devserver.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host == r.Host
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("content-type", "application/json")
+	w.Header().Set("cache-control", "no-cache")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("control: json encode error: %v", err)
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestControlHandler() (*controlHandler, chan restartRequest) {
+	restart := make(chan restartRequest, 1)
+	stream := newEventStream(10)
+	status := newServerStatus(stream, true)
+	return &controlHandler{
+		restart:       restart,
+		stream:        stream,
+		status:        status,
+		logs:          newLogMux(io.Discard, stream, false),
+		network:       newNetworkSimulator(netConditions{}),
+		setLiveReload: status.SetLiveReload,
+	}, restart
+}
+
+func TestControlHandler_Status(t *testing.T) {
+	h, _ := newTestControlHandler()
+	h.status.BuildDone(1500*time.Millisecond, true)
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/_dev/api/status", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status\nwant: %d\ngot:  %d", http.StatusOK, rec.Code)
+	}
+
+	var got statusSnapshot
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got.State != stateIdle || got.LastBuild != 1500 || !got.LastBuildOK || !got.LiveReload {
+		t.Errorf("unexpected snapshot: %+v", got)
+	}
+}
+
+func TestControlHandler_Restart(t *testing.T) {
+	tests := []struct {
+		endpoint string
+		want     restartRequest
+	}{
+		{"rebuild", restartRequest{build: true}},
+		{"restart", restartRequest{build: false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.endpoint, func(t *testing.T) {
+			h, restart := newTestControlHandler()
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("POST", "/_dev/api/"+tt.endpoint, nil))
+
+			if rec.Code != http.StatusAccepted {
+				t.Errorf("unexpected status\nwant: %d\ngot:  %d", http.StatusAccepted, rec.Code)
+			}
+			select {
+			case got := <-restart:
+				if got != tt.want {
+					t.Errorf("unexpected request\nwant: %+v\ngot:  %+v", tt.want, got)
+				}
+			default:
+				t.Error("expected a restart request")
+			}
+		})
+	}
+}
+
+func TestControlHandler_LiveReload(t *testing.T) {
+	h, _ := newTestControlHandler()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/_dev/api/live-reload", strings.NewReader(`{"enabled": false}`)))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("unexpected status\nwant: %d\ngot:  %d", http.StatusOK, rec.Code)
+	}
+	if h.status.LiveReload() {
+		t.Error("expected live reload to be disabled")
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/_dev/api/live-reload", strings.NewReader(`{}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status\nwant: %d\ngot:  %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestControlHandler_Network(t *testing.T) {
+	h, _ := newTestControlHandler()
+	h.network.Set(netConditions{Latency: jsonDuration(time.Second), Faults: []netFault{{Path: "/api/**", Kind: "503", Rate: 0.5}}})
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/_dev/api/network", strings.NewReader(`{"enabled": true, "jitter": "20ms"}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status\nwant: %d\ngot:  %d", http.StatusOK, rec.Code)
+	}
+	var got netConditions
+	if err := json.NewDecoder(rec.Body).Decode(&got); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := netConditions{
+		Enabled: true,
+		Latency: jsonDuration(time.Second),
+		Jitter:  jsonDuration(20 * time.Millisecond),
+		Faults:  []netFault{{Path: "/api/**", Kind: "503", Rate: 0.5}},
+	}
+	if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(h.network.Conditions(), want) {
+		t.Errorf("unexpected conditions\nwant: %+v\ngot:  %+v", want, got)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/_dev/api/network", strings.NewReader(`{"faults": [{"kind": "reset", "rate": 1}]}`)))
+	want.Faults = []netFault{{Kind: "reset", Rate: 1}}
+	if got := h.network.Conditions(); rec.Code != http.StatusOK || !reflect.DeepEqual(got, want) {
+		t.Errorf("expected the faults to be replaced\nwant: %+v\ngot:  %+v", want, got)
+	}
+
+	for _, body := range []string{`{"latency": "fast"}`, `{"faults": [{"kind": "200", "rate": 1}]}`} {
+		rec = httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("POST", "/_dev/api/network", strings.NewReader(body)))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: unexpected status\nwant: %d\ngot:  %d", body, http.StatusBadRequest, rec.Code)
+		}
+	}
+	if !reflect.DeepEqual(h.network.Conditions(), want) {
+		t.Errorf("expected invalid conditions to be rejected, got %+v", h.network.Conditions())
+	}
+}
+
+func TestControlHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		origin string
+		want   int
+	}{
+		{"Unknown endpoint", "POST", "/_dev/api/unknown", "", http.StatusNotFound},
+		{"Wrong method", "GET", "/_dev/api/rebuild", "", http.StatusMethodNotAllowed},
+		{"Cross origin", "POST", "/_dev/api/rebuild", "http://evil.test", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, _ := newTestControlHandler()
+
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			if tt.origin != "" {
+				req.Header.Set("Origin", tt.origin)
+			}
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Errorf("unexpected status\nwant: %d\ngot:  %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestControlHandler_RestartWait(t *testing.T) {
+	tests := []struct {
+		name  string
+		state string
+		want  int
+	}{
+		{"Success", stateIdle, http.StatusOK},
+		{"Build failed", stateError, http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, restart := newTestControlHandler()
+
+			go func() {
+				req := <-restart
+				h.status.SetState(tt.state, "build failed")
+				req.done <- h.status.Snapshot()
+			}()
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("POST", "/_dev/api/rebuild?wait=1", nil))
+
+			if rec.Code != tt.want {
+				t.Errorf("unexpected status\nwant: %d\ngot:  %d", tt.want, rec.Code)
+			}
+		})
+	}
+}
+
+func TestControlHandler_Reload(t *testing.T) {
+	h, _ := newTestControlHandler()
+	_, ch, remove := h.stream.Subscribe(0, eventChange)
+	defer remove()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("POST", "/_dev/api/reload", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("unexpected status\nwant: %d\ngot:  %d", http.StatusOK, rec.Code)
+	}
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Error("expected a change event")
+	}
+}
+
+func TestServerStatus_ServerStopped(t *testing.T) {
+	s := newServerStatus(newEventStream(10), true)
+
+	s.ServerStarted(42)
+	s.ServerStopped(41)
+	if got := s.Snapshot(); got.PID != 42 || got.State != stateIdle {
+		t.Errorf("unexpected snapshot after stopping an old server: %+v", got)
+	}
+
+	s.ServerStopped(42)
+	if got := s.Snapshot(); got.PID != 0 || got.State != stateError {
+		t.Errorf("unexpected snapshot after the server exited: %+v", got)
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// cssMapping maps source files matching Pattern (e.g. .scss files) to the
+// URL of the stylesheet they are compiled to.
+type cssMapping struct {
+	Pattern string
+	URL     string
+}
+
+// cssMapFlag collects cssMappings from repeated -css-map flags.
+type cssMapFlag []cssMapping
+
+func (f *cssMapFlag) String() string {
+	var s []string
+	for _, m := range *f {
+		s = append(s, m.Pattern+"="+m.URL)
+	}
+	return strings.Join(s, ",")
+}
+
+func (f *cssMapFlag) Set(v string) error {
+	pattern, url, ok := strings.Cut(v, "=")
+	if !ok || pattern == "" || url == "" {
+		return fmt.Errorf("expected pattern=url, got %q", v)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+	}
+	if !strings.HasPrefix(url, "/") {
+		url = "/" + url
+	}
+	*f = append(*f, cssMapping{Pattern: pattern, URL: url})
+	return nil
+}
+
+// cssResolver finds the stylesheets served from the web root that are
+// affected by a change to a file.
+type cssResolver struct {
+	webRoot  string // absolute path, empty if not set
+	dir      string // directory the mapping patterns are relative to
+	mappings []cssMapping
+
+	mu sync.Mutex
+	// imports maps the CSS files under the web root, and the CSS files
+	// outside of it they import, to the files they import. It is built on
+	// first use and kept up to date by Update.
+	imports map[string][]string
+}
+
+func newCSSResolver(webRoot string, mappings []cssMapping) *cssResolver {
+	r := &cssResolver{mappings: mappings}
+
+	if dir, err := os.Getwd(); err == nil {
+		r.dir = dir
+	} else {
+		log.Printf("cssResolver: %v", err)
+	}
+
+	if webRoot != "" {
+		if abs, err := filepath.Abs(webRoot); err == nil {
+			r.webRoot = abs
+		} else {
+			log.Printf("cssResolver: %v", err)
+		}
+	}
+
+	return r
+}
+
+// Affected returns the URLs of the stylesheets that have to be reloaded when
+// file changes. Stylesheets importing a CSS file directly or indirectly via
+// @import and the outputs of matching mappings are returned. file itself is
+// not included.
+func (r *cssResolver) Affected(file string) []string {
+	var urls []string
+
+	if rel, err := filepath.Rel(r.dir, file); err == nil {
+		rel = filepath.ToSlash(rel)
+		for _, m := range r.mappings {
+			if matchGlob(m.Pattern, rel) {
+				urls = append(urls, m.URL)
+			}
+		}
+	}
+
+	if r.webRoot != "" && filepath.Ext(file) == ".css" {
+		for _, css := range r.importers(file) {
+			if rel, err := filepath.Rel(r.webRoot, css); err == nil {
+				urls = append(urls, "/"+filepath.ToSlash(rel))
+			}
+		}
+	}
+
+	slices.Sort(urls)
+	return slices.Compact(urls)
+}
+
+// Update re-reads the imports of the CSS files changed in b. Call it before
+// Affected for the files of b.
+func (r *cssResolver) Update(b fsEventBatch) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.imports == nil {
+		// Not built yet, the files are read on first use.
+		return
+	}
+	for _, e := range b {
+		if filepath.Ext(e.File) == ".css" {
+			r.parse(e.File)
+		}
+	}
+}
+
+// importers returns the CSS files under the web root that import file
+// directly or indirectly.
+func (r *cssResolver) importers(file string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.imports == nil {
+		r.build()
+	}
+
+	// importedBy maps a file to the stylesheets importing it.
+	importedBy := make(map[string][]string)
+	for css, targets := range r.imports {
+		for _, target := range targets {
+			importedBy[target] = append(importedBy[target], css)
+		}
+	}
+
+	var (
+		affected []string
+		seen     = map[string]bool{file: true}
+		queue    = []string{file}
+	)
+	for len(queue) > 0 {
+		f := queue[0]
+		queue = queue[1:]
+		for _, css := range importedBy[f] {
+			if seen[css] {
+				continue
+			}
+			seen[css] = true
+			queue = append(queue, css)
+			if strings.HasPrefix(css, r.webRoot+string(filepath.Separator)) {
+				affected = append(affected, css)
+			}
+		}
+	}
+	return affected
+}
+
+// build reads the imports of every CSS file under the web root. r.mu must be
+// held.
+func (r *cssResolver) build() {
+	r.imports = make(map[string][]string)
+	err := filepath.WalkDir(r.webRoot, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if !d.IsDir() && filepath.Ext(p) == ".css" {
+			r.parse(p)
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("cssResolver: %v", err)
+	}
+}
+
+// parse records the imports of css. Files that do not exist anymore are
+// removed. r.mu must be held.
+func (r *cssResolver) parse(css string) {
+	b, err := os.ReadFile(css)
+	if err != nil {
+		delete(r.imports, css)
+		return
+	}
+	var targets []string
+	for _, imp := range parseImports(b) {
+		if target := r.resolveImport(css, imp); target != "" {
+			targets = append(targets, target)
+		}
+	}
+	r.imports[css] = targets
+
+	for _, target := range targets {
+		// Imported files outside of the web root may import other files
+		// too.
+		if _, seen := r.imports[target]; !seen && filepath.Ext(target) == ".css" &&
+			!strings.HasPrefix(target, r.webRoot+string(filepath.Separator)) {
+			r.parse(target)
+		}
+	}
+}
+
+// resolveImport returns the path of the file imported by css. Imports
+// starting with / are relative to the web root. It returns an empty string
+// for imports of external URLs.
+func (r *cssResolver) resolveImport(css, imp string) string {
+	if strings.Contains(imp, "://") || strings.HasPrefix(imp, "//") || strings.HasPrefix(imp, "data:") {
+		return ""
+	}
+	imp, _, _ = strings.Cut(imp, "?")
+	imp, _, _ = strings.Cut(imp, "#")
+
+	if strings.HasPrefix(imp, "/") {
+		return filepath.Join(r.webRoot, filepath.FromSlash(imp))
+	}
+	return filepath.Join(filepath.Dir(css), filepath.FromSlash(imp))
+}
+
+var importRe = regexp.MustCompile(`@import\s+(?:url\(\s*)?(?:"([^"]+)"|'([^']+)'|([^\s'")]+))`)
+
+// parseImports returns the targets of the @import rules in css.
+func parseImports(css []byte) []string {
+	var imports []string
+	for _, m := range importRe.FindAllSubmatch(css, -1) {
+		for _, g := range m[1:] {
+			if len(g) > 0 {
+				imports = append(imports, string(g))
+				break
+			}
+		}
+	}
+	return imports
+}
+
+// matchGlob reports whether name matches pattern. Both are slash separated.
+// In addition to the syntax of path.Match, a ** path element matches zero
+// or more path elements.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
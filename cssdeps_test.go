@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseImports(t *testing.T) {
+	css := `
+@import "base.css";
+@import 'theme.css' screen;
+@import url(layout.css);
+@import url("/css/print.css") print;
+@import url( 'fonts.css' );
+body { color: red; }
+`
+	want := []string{"base.css", "theme.css", "layout.css", "/css/print.css", "fonts.css"}
+
+	if got := parseImports([]byte(css)); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected imports\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"scss/*.scss", "scss/app.scss", true},
+		{"scss/*.scss", "scss/partials/_vars.scss", false},
+		{"scss/**/*.scss", "scss/app.scss", true},
+		{"scss/**/*.scss", "scss/partials/_vars.scss", true},
+		{"scss/**/*.scss", "other/app.scss", false},
+		{"**/*.less", "a/b/c.less", true},
+		{"app.scss", "app.scss", true},
+		{"app.scss", "app.css", false},
+	}
+
+	for _, tt := range tests {
+		if got := matchGlob(tt.pattern, tt.name); got != tt.want {
+			t.Errorf("matchGlob(%q, %q) = %t, want %t", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCSSMapFlag(t *testing.T) {
+	var f cssMapFlag
+	if err := f.Set("scss/**/*.scss=css/app.css"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := cssMapFlag{{Pattern: "scss/**/*.scss", URL: "/css/app.css"}}
+	if !reflect.DeepEqual(f, want) {
+		t.Errorf("unexpected mappings\nwant: %v\ngot:  %v", want, f)
+	}
+
+	for _, v := range []string{"", "scss/*.scss", "=/css/app.css", "[=/css/app.css"} {
+		if err := f.Set(v); err == nil {
+			t.Errorf("expected an error for %q but got none", v)
+		}
+	}
+}
+
+func TestCSSResolver_Affected(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		t.Helper()
+		p := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	writeFile("web/css/app.css", `@import "base.css"; @import url(/css/theme.css);`)
+	base := writeFile("web/css/base.css", `@import "../../styles/_reset.css";`)
+	theme := writeFile("web/css/theme.css", `body {}`)
+	writeFile("web/css/other.css", `@import "https://example.com/x.css";`)
+	reset := writeFile("styles/_reset.css", `@import "_vars.css";`)
+	vars := writeFile("styles/_vars.css", `:root {}`)
+	scss := writeFile("scss/partials/_vars.scss", ``)
+
+	r := &cssResolver{
+		webRoot:  filepath.Join(dir, "web"),
+		dir:      dir,
+		mappings: []cssMapping{{Pattern: "scss/**/*.scss", URL: "/css/compiled.css"}},
+	}
+
+	tests := []struct {
+		name string
+		file string
+		want []string
+	}{
+		{"Direct import", base, []string{"/css/app.css"}},
+		{"Absolute import", theme, []string{"/css/app.css"}},
+		{"Import outside of web root", reset, []string{"/css/app.css", "/css/base.css"}},
+		{"Transitive import", vars, []string{"/css/app.css", "/css/base.css"}},
+		{"Mapped source", scss, []string{"/css/compiled.css"}},
+		{"Not imported", filepath.Join(dir, "web/css/app.css"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := r.Affected(tt.file); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected stylesheets\nwant: %q\ngot:  %q", tt.want, got)
+			}
+		})
+	}
+
+	// Changed stylesheets update the import graph.
+	extra := writeFile("web/css/extra.css", `@import "theme.css";`)
+	writeFile("web/css/base.css", `body {}`)
+	r.Update(fsEventBatch{{File: extra, Ext: ".css"}, {File: base, Ext: ".css"}})
+	if got, want := r.Affected(theme), []string{"/css/app.css", "/css/extra.css"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected stylesheets after update\nwant: %q\ngot:  %q", want, got)
+	}
+	if got := r.Affected(vars); got != nil {
+		t.Errorf("expected no stylesheets after update, got %q", got)
+	}
+
+	// Only CSS files are looked up in the import graph.
+	png := writeFile("web/img/logo.png", "")
+	writeFile("web/css/logo.css", `@import "../img/logo.png";`)
+	r.Update(fsEventBatch{{File: filepath.Join(dir, "web/css/logo.css"), Ext: ".css"}})
+	if got := r.Affected(png); got != nil {
+		t.Errorf("expected no stylesheets for %s, got %q", png, got)
+	}
+}
@@ -0,0 +1,578 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lockfileName is the name of the file devserver writes to the project
+// directory. devserver ctl uses it to find the running instance.
+const lockfileName = ".devserver.lock"
+
+type lockfile struct {
+	PID    int    `json:"pid"`
+	Addr   string `json:"addr"`             // host:port of the proxy
+	Socket string `json:"socket,omitempty"` // absolute path of the control socket
+	TLS    bool   `json:"tls,omitempty"`    // the proxy serves HTTPS
+	CA     string `json:"ca,omitempty"`     // certificate of the CA issuing the proxy's certificate
+}
+
+// writeLockfile writes lf to dir. The returned function removes the lockfile.
+func writeLockfile(dir string, lf lockfile) (func(), error) {
+	b, err := json.MarshalIndent(lf, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	p := filepath.Join(dir, lockfileName)
+	if err := os.WriteFile(p, append(b, '\n'), 0o644); err != nil {
+		return nil, fmt.Errorf("lockfile: %w", err)
+	}
+	return func() { os.Remove(p) }, nil
+}
+
+// findLockfile looks for the lockfile in dir and its parents.
+func findLockfile(dir string) (lockfile, error) {
+	for {
+		b, err := os.ReadFile(filepath.Join(dir, lockfileName))
+		if err == nil {
+			var lf lockfile
+			if err := json.Unmarshal(b, &lf); err != nil {
+				return lockfile{}, fmt.Errorf("lockfile %s: %w", filepath.Join(dir, lockfileName), err)
+			}
+			return lf, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return lockfile{}, fmt.Errorf("lockfile: %w", err)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return lockfile{}, errors.New("no running devserver found, " + lockfileName + " does not exist")
+		}
+		dir = parent
+	}
+}
+
+// ctlClient talks to the control API of a running devserver.
+type ctlClient struct {
+	client *http.Client
+	base   string
+}
+
+// newCtlClient creates a client for the devserver described by lf. The
+// control socket is preferred if there is one.
+func newCtlClient(lf lockfile) (*ctlClient, error) {
+	if lf.Socket != "" {
+		return &ctlClient{
+			client: &http.Client{
+				Transport: &http.Transport{
+					DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+						var d net.Dialer
+						return d.DialContext(ctx, "unix", lf.Socket)
+					},
+				},
+			},
+			base: "http://devserver",
+		}, nil
+	}
+
+	base, tlsConfig, err := lf.proxyURL()
+	if err != nil {
+		return nil, err
+	}
+	return &ctlClient{
+		client: &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}},
+		base:   base,
+	}, nil
+}
+
+// proxyURL returns the URL of the proxy and, if it serves HTTPS, the TLS
+// configuration trusting its CA.
+func (lf lockfile) proxyURL() (string, *tls.Config, error) {
+	if _, _, err := net.SplitHostPort(lf.Addr); err != nil {
+		return "", nil, fmt.Errorf("lockfile: addr is not host:port: %w", err)
+	}
+	if !lf.TLS {
+		return "http://" + dialAddr(lf.Addr), nil, nil
+	}
+	pool, err := loadCertPool(lf.CA)
+	if err != nil {
+		return "", nil, fmt.Errorf("lockfile: %w", err)
+	}
+	return "https://" + dialAddr(lf.Addr), &tls.Config{RootCAs: pool}, nil
+}
+
+// dialAddr returns the address to connect to a server listening on addr. An
+// unspecified host, e.g. ":8080" or "0.0.0.0:8080", is replaced with
+// 127.0.0.1.
+func dialAddr(addr string) string {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return addr
+	}
+	if ip := net.ParseIP(host); host == "" || (ip != nil && ip.IsUnspecified()) {
+		host = "127.0.0.1"
+	}
+	return net.JoinHostPort(host, port)
+}
+
+// do sends a request to the control API and decodes the JSON response into
+// v. It returns the HTTP status code.
+func (c *ctlClient) do(method, endpoint string, v any) (int, error) {
+	return c.request(method, controlPrefix+endpoint, nil, v)
+}
+
+// request sends a request for path with body encoded as JSON, unless it is
+// nil, and decodes the JSON response into v. It returns the HTTP status code.
+func (c *ctlClient) request(method, path string, body, v any) (int, error) {
+	var r io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, err
+		}
+		r = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, c.base+path, r)
+	if err != nil {
+		return 0, err
+	}
+	if body != nil {
+		req.Header.Set("content-type", "application/json")
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if !strings.HasPrefix(resp.Header.Get("content-type"), "application/json") {
+		b, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(b)))
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(v)
+}
+
+// follow streams the events of the given type from the event stream and
+// calls f for each event. devserver drops listeners that fall behind, follow
+// then reconnects and resumes after the last event it received. It returns
+// when devserver shuts down.
+func (c *ctlClient) follow(lastID uint64, typ string, f func(data []byte)) error {
+	for {
+		shutdown, err := c.stream(&lastID, typ, f)
+		if err != nil || shutdown {
+			return err
+		}
+	}
+}
+
+// stream reads the events of the given type that come after *lastID until
+// the connection is closed and updates *lastID as events arrive. It reports
+// whether devserver is shutting down.
+func (c *ctlClient) stream(lastID *uint64, typ string, f func(data []byte)) (shutdown bool, err error) {
+	req, err := http.NewRequest("GET", c.base+"/_dev?events="+typ+","+eventShutdown, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Last-Event-ID", fmt.Sprint(*lastID))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("event stream: %s", resp.Status)
+	}
+	err = readEvents(resp.Body, func(id uint64, eventType string, data []byte) {
+		if id != 0 {
+			*lastID = id
+		}
+		switch eventType {
+		case typ:
+			f(data)
+		case eventShutdown:
+			shutdown = true
+		}
+	})
+	return shutdown, err
+}
+
+// readEvents parses a server-sent events stream and calls f for every event.
+// id is 0 for events without an ID.
+func readEvents(r io.Reader, f func(id uint64, eventType string, data []byte)) error {
+	var (
+		id        uint64
+		eventType string
+		data      []byte
+	)
+
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1<<20)
+	for s.Scan() {
+		line := s.Text()
+		switch {
+		case line == "":
+			if data != nil {
+				f(id, eventType, data)
+			}
+			id, eventType, data = 0, "", nil
+		case strings.HasPrefix(line, "id:"):
+			id, _ = strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "id:")), 10, 64)
+		case strings.HasPrefix(line, "event:"):
+			eventType = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if data != nil {
+				data = append(data, '\n')
+			}
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+		}
+	}
+	return s.Err()
+}
+
+const ctlUsage = `Usage: %s ctl <command> [options]
+
+Control the devserver running in the current project. The running instance
+is found via the %s file in the current directory or its parents.
+
+Commands:
+  rebuild   rebuild and restart the server, waits for the restart to finish
+  restart   restart the server without building, waits for the restart to finish
+  reload    reload the connected browsers
+  status    print the status of devserver
+  logs      print recent build, server and watcher output, -f follows the output
+  network   print the simulated network conditions, network on|off enables
+            or disables the simulation
+  har       print the recorded requests in HAR format
+  replay    replay the requests of a HAR file and compare the responses,
+            e.g. ctl replay [-target url] session.har
+
+`
+
+// runCtl runs the ctl subcommand and returns the exit code.
+func runCtl(args []string, stdout, stderr io.Writer) int {
+	fset := flag.NewFlagSet("ctl", flag.ContinueOnError)
+	fset.SetOutput(stderr)
+	fset.Usage = func() {
+		fmt.Fprintf(stderr, ctlUsage, os.Args[0], lockfileName)
+		fset.PrintDefaults()
+	}
+	noWait := fset.Bool("no-wait", false, "rebuild/restart: return without waiting for the restart to finish")
+	asJSON := fset.Bool("json", false, "status: print the status as JSON")
+	followLogs := fset.Bool("f", false, "logs: follow the output")
+	source := fset.String("source", "", "logs: comma separated sources to print: build, server, watch")
+	lines := fset.Int("n", 0, "logs: number of recent lines to print, 0 prints every kept line")
+	target := fset.String("target", "", "replay: base URL to send the requests to (default the running devserver)")
+	ignoreHeaders := fset.String("ignore-header", "", "replay: comma separated response headers not to compare, in addition to Age, Content-Length, Date, Expires and "+requestIDHeader)
+
+	if len(args) == 0 {
+		fset.Usage()
+		return 2
+	}
+	cmd := args[0]
+	if cmd == "help" || cmd == "-h" || cmd == "-help" || cmd == "--help" {
+		fset.Usage()
+		return 0
+	}
+	if err := fset.Parse(args[1:]); err != nil {
+		return 2
+	}
+
+	if cmd == "replay" {
+		return runReplay(fset.Args(), *target, splitList(*ignoreHeaders), stdout, stderr)
+	}
+
+	lf, err := findDevserver()
+	if err != nil {
+		fmt.Fprintf(stderr, "ctl: %v\n", err)
+		return 1
+	}
+	c, err := newCtlClient(lf)
+	if err != nil {
+		fmt.Fprintf(stderr, "ctl: %v\n", err)
+		return 1
+	}
+
+	opts := ctlOptions{
+		noWait:  *noWait,
+		json:    *asJSON,
+		follow:  *followLogs,
+		lines:   *lines,
+		sources: splitList(*source),
+		args:    fset.Args(),
+	}
+	return c.run(cmd, opts, stdout, stderr)
+}
+
+// findDevserver returns the lockfile of the devserver running in the current
+// directory or its parents.
+func findDevserver() (lockfile, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return lockfile{}, err
+	}
+	lf, err := findLockfile(dir)
+	if err != nil {
+		return lockfile{}, err
+	}
+	if lf.PID != 0 && !processAlive(lf.PID) {
+		return lockfile{}, fmt.Errorf("devserver (pid %d) is not running", lf.PID)
+	}
+	return lf, nil
+}
+
+// runReplay replays the HAR file in args against target, or the running
+// devserver if target is empty. It exits with 1 if a response differs.
+func runReplay(args []string, target string, ignoreHeaders []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "ctl: replay: expected a HAR file")
+		return 2
+	}
+
+	var tlsConfig *tls.Config
+	if target == "" {
+		lf, err := findDevserver()
+		if err == nil {
+			target, tlsConfig, err = lf.proxyURL()
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "ctl: replay: %v\n", err)
+			return 1
+		}
+	}
+	u, err := url.Parse(target)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		fmt.Fprintf(stderr, "ctl: replay: invalid target %q\n", target)
+		return 2
+	}
+
+	b, err := os.ReadFile(args[0])
+	if err != nil {
+		fmt.Fprintf(stderr, "ctl: replay: %v\n", err)
+		return 1
+	}
+	var har harFile
+	if err := json.Unmarshal(b, &har); err != nil {
+		fmt.Fprintf(stderr, "ctl: replay: %s: %v\n", args[0], err)
+		return 1
+	}
+
+	if newReplayer(u, ignoreHeaders, tlsConfig).Run(har, stdout) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// splitList splits a comma separated flag value.
+func splitList(s string) []string {
+	var list []string
+	for v := range strings.SplitSeq(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			list = append(list, v)
+		}
+	}
+	return list
+}
+
+type ctlOptions struct {
+	noWait  bool
+	json    bool
+	follow  bool
+	sources []string // logs: print these sources only
+	lines   int      // logs: number of recent lines
+	args    []string // positional arguments
+}
+
+func (c *ctlClient) run(cmd string, opts ctlOptions, stdout, stderr io.Writer) int {
+	switch cmd {
+	case "rebuild", "restart":
+		endpoint := cmd
+		if !opts.noWait {
+			endpoint += "?wait=1"
+		}
+
+		var st statusSnapshot
+		code, err := c.do("POST", endpoint, &st)
+		if err != nil {
+			fmt.Fprintf(stderr, "ctl: %s: %v\n", cmd, err)
+			return 1
+		}
+		printStatus(stdout, st)
+		if code >= 300 {
+			return 1
+		}
+		return 0
+
+	case "reload":
+		var st statusSnapshot
+		if _, err := c.do("POST", "reload", &st); err != nil {
+			fmt.Fprintf(stderr, "ctl: reload: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(stdout, "reloaded %d clients\n", st.Clients)
+		return 0
+
+	case "status":
+		var st statusSnapshot
+		if _, err := c.do("GET", "status", &st); err != nil {
+			fmt.Fprintf(stderr, "ctl: status: %v\n", err)
+			return 1
+		}
+		if opts.json {
+			enc := json.NewEncoder(stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(st)
+		} else {
+			printStatus(stdout, st)
+		}
+		if st.State == stateError {
+			return 1
+		}
+		return 0
+
+	case "logs":
+		q := url.Values{}
+		if len(opts.sources) > 0 {
+			q.Set("source", strings.Join(opts.sources, ","))
+		}
+		if opts.lines > 0 {
+			q.Set("lines", strconv.Itoa(opts.lines))
+		}
+		endpoint := "logs"
+		if len(q) > 0 {
+			endpoint += "?" + q.Encode()
+		}
+
+		var logs logsResponse
+		if _, err := c.do("GET", endpoint, &logs); err != nil {
+			fmt.Fprintf(stderr, "ctl: logs: %v\n", err)
+			return 1
+		}
+		for _, e := range logs.Entries {
+			printLogEntry(stdout, e)
+		}
+		if !opts.follow {
+			return 0
+		}
+
+		err := c.follow(logs.LastID, eventLog, func(data []byte) {
+			var e logEntry
+			if err := json.Unmarshal(data, &e); err != nil {
+				fmt.Fprintf(stderr, "ctl: logs: %v\n", err)
+				return
+			}
+			if len(opts.sources) == 0 || slices.Contains(opts.sources, e.Source) {
+				printLogEntry(stdout, e)
+			}
+		})
+		if err != nil {
+			fmt.Fprintf(stderr, "ctl: logs: %v\n", err)
+			return 1
+		}
+		return 0
+
+	case "network":
+		var cond netConditions
+		var err error
+		switch {
+		case len(opts.args) == 0:
+			_, err = c.do("GET", "network", &cond)
+		case len(opts.args) == 1 && (opts.args[0] == "on" || opts.args[0] == "off"):
+			body := map[string]bool{"enabled": opts.args[0] == "on"}
+			_, err = c.request("POST", controlPrefix+"network", body, &cond)
+		default:
+			fmt.Fprintln(stderr, "ctl: network: expected on or off")
+			return 2
+		}
+		if err != nil {
+			fmt.Fprintf(stderr, "ctl: network: %v\n", err)
+			return 1
+		}
+		printNetConditions(stdout, cond)
+		return 0
+
+	case "har":
+		var har harFile
+		if _, err := c.request("GET", requestsPath+"/har", nil, &har); err != nil {
+			fmt.Fprintf(stderr, "ctl: har: %v\n", err)
+			return 1
+		}
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(har)
+		return 0
+
+	default:
+		fmt.Fprintf(stderr, "ctl: unknown command %q\n", cmd)
+		return 2
+	}
+}
+
+func printStatus(w io.Writer, st statusSnapshot) {
+	if st.Error != "" {
+		fmt.Fprintf(w, "state:       %s (%s)\n", st.State, st.Error)
+	} else {
+		fmt.Fprintf(w, "state:       %s\n", st.State)
+	}
+	if !st.LastBuildAt.IsZero() {
+		result := "ok"
+		if !st.LastBuildOK {
+			result = "failed"
+		}
+		fmt.Fprintf(w, "last build:  %s, took %s\n", result, time.Duration(st.LastBuild)*time.Millisecond)
+	}
+	if st.PID != 0 {
+		fmt.Fprintf(w, "server:      pid %d, up %s\n", st.PID, (time.Duration(st.ServerUptime) * time.Millisecond).Round(time.Second))
+	} else {
+		fmt.Fprintln(w, "server:      not running")
+	}
+	fmt.Fprintf(w, "live reload: %t\n", st.LiveReload)
+	fmt.Fprintf(w, "clients:     %d\n", st.Clients)
+}
+
+func printNetConditions(w io.Writer, c netConditions) {
+	state := "off"
+	if c.Enabled {
+		state = "on"
+	}
+	fmt.Fprintf(w, "simulation: %s\n", state)
+	fmt.Fprintf(w, "latency:    %s ± %s\n", time.Duration(c.Latency), time.Duration(c.Jitter))
+	if c.Bandwidth > 0 {
+		fmt.Fprintf(w, "bandwidth:  %d KiB/s\n", c.Bandwidth)
+	} else {
+		fmt.Fprintln(w, "bandwidth:  unlimited")
+	}
+	for _, f := range c.Faults {
+		p := f.Path
+		if p == "" {
+			p = "every path"
+		}
+		fmt.Fprintf(w, "fault:      %s at %g%% of %s\n", f.Kind, f.Rate*100, p)
+	}
+}
+
+func printLogEntry(w io.Writer, e logEntry) {
+	for line := range Lines(e.Text) {
+		fmt.Fprintf(w, "%s%s\n", logPrefix(e), line)
+	}
+}
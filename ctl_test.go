@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFindLockfile(t *testing.T) {
+	dir := t.TempDir()
+	want := lockfile{PID: 42, Addr: "127.0.0.1:8080"}
+	remove, err := writeLockfile(dir, want)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sub := filepath.Join(dir, "a", "b")
+	if err := os.MkdirAll(sub, 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := findLockfile(sub)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != want {
+		t.Errorf("unexpected lockfile\nwant: %+v\ngot:  %+v", want, got)
+	}
+
+	remove()
+	if _, err := findLockfile(sub); err == nil {
+		t.Error("expected an error but got none")
+	}
+}
+
+func TestNewCtlClient(t *testing.T) {
+	tests := []struct {
+		addr string
+		base string
+	}{
+		{"127.0.0.1:8080", "http://127.0.0.1:8080"},
+		{":8080", "http://127.0.0.1:8080"},
+		{"0.0.0.0:8080", "http://127.0.0.1:8080"},
+		{"[::]:8080", "http://127.0.0.1:8080"},
+		{"localhost:9000", "http://localhost:9000"},
+	}
+
+	for _, tt := range tests {
+		c, err := newCtlClient(lockfile{Addr: tt.addr})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if c.base != tt.base {
+			t.Errorf("newCtlClient(%q)\nwant: %s\ngot:  %s", tt.addr, tt.base, c.base)
+		}
+	}
+}
+
+func TestReadEvents(t *testing.T) {
+	stream := "retry: 1000\n\n: heartbeat\n\nid: 1\nevent: log\ndata: {\"a\":1}\n\nevent: status\ndata: line1\ndata: line2\n\n"
+
+	var got []string
+	err := readEvents(strings.NewReader(stream), func(id uint64, eventType string, data []byte) {
+		got = append(got, fmt.Sprintf("%d %s=%s", id, eventType, data))
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := []string{`1 log={"a":1}`, "0 status=line1\nline2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected events\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestCtlClient_Follow(t *testing.T) {
+	// The history keeps every event, so none is lost when the client falls
+	// behind and reconnects.
+	const n = 2000
+	stream := newEventStream(n)
+	var connects atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connects.Add(1)
+		(&watchHandler{stream: stream}).ServeHTTP(w, r)
+	}))
+	defer srv.Close()
+
+	c := &ctlClient{client: srv.Client(), base: srv.URL}
+
+	// The client does not read while the events are published. The large
+	// events fill the connection and the buffer of the listener, so it is
+	// dropped.
+	text := strings.Repeat("x", 10<<10)
+	stream.Publish(eventLog, logEntry{Text: "0 " + text})
+	started, published := make(chan struct{}), make(chan struct{})
+	go func() {
+		<-started
+		for i := 1; i < n; i++ {
+			stream.Publish(eventLog, logEntry{Text: fmt.Sprintf("%d %s", i, text)})
+		}
+		close(published)
+		stream.Shutdown()
+	}()
+
+	var got int
+	err := c.follow(0, eventLog, func(data []byte) {
+		if got == 0 {
+			close(started)
+			<-published
+		}
+		var e logEntry
+		json.Unmarshal(data, &e)
+		if want := fmt.Sprintf("%d %s", got, text); e.Text != want {
+			t.Fatalf("unexpected event\nwant: %.10s\ngot:  %.10s", want, e.Text)
+		}
+		got++
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if got != n {
+		t.Errorf("unexpected number of events\nwant: %d\ngot:  %d", n, got)
+	}
+	if connects.Load() < 2 {
+		t.Errorf("expected the client to reconnect")
+	}
+}
+
+func TestCtlClient_Run(t *testing.T) {
+	h, restart := newTestControlHandler()
+	h.logs.Print(sourceBuild, streamStdout, "line1\nline2\n")
+	h.logs.Print(sourceServer, streamStderr, "oops")
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	c := &ctlClient{client: srv.Client(), base: srv.URL}
+
+	tests := []struct {
+		name       string
+		cmd        string
+		buildState string
+		want       int
+		output     string
+	}{
+		{"Rebuild", "rebuild", stateIdle, 0, "state:       idle"},
+		{"Rebuild failed", "rebuild", stateError, 1, "state:       error (build failed)"},
+		{"Logs", "logs", "", 0, "[build] line1\n[build] line2\n[server:err] oops\n"},
+		{"Unknown", "unknown", "", 2, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.buildState != "" {
+				go func() {
+					req := <-restart
+					h.status.SetState(tt.buildState, "build failed")
+					req.done <- h.status.Snapshot()
+				}()
+			}
+
+			var stdout, stderr bytes.Buffer
+			if code := c.run(tt.cmd, ctlOptions{}, &stdout, &stderr); code != tt.want {
+				t.Errorf("unexpected exit code\nwant: %d\ngot:  %d\nstderr: %s", tt.want, code, stderr.String())
+			}
+			if !strings.Contains(stdout.String(), tt.output) {
+				t.Errorf("unexpected output\nwant: %s\ngot:  %s", tt.output, stdout.String())
+			}
+		})
+	}
+}
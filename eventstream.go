@@ -0,0 +1,181 @@
+package main
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+)
+
+// Event types sent on the /_dev event stream.
+const (
+	eventChange = "change" // files changed or the server restarted
+	eventLog    = "log"    // output of the build or the server
+
+	// eventShutdown is the last event on the stream, devserver is going
+	// away.
+	eventShutdown = "shutdown"
+)
+
+// devEvent is a single message on the /_dev event stream. Every event gets a
+// unique, monotonically increasing ID so that reconnecting clients can ask
+// for the events they missed.
+type devEvent struct {
+	ID   uint64
+	Type string // SSE event name
+	Data any    // JSON encoded as the event's data
+	Time time.Time
+}
+
+// changeData is the payload of eventChange events.
+type changeData struct {
+	Events fsEventBatch `json:"events"`
+	Time   time.Time    `json:"time"`
+}
+
+// eventStream assigns IDs to published events, broadcasts them to the
+// listeners, and keeps the last few events of every type around for replay.
+// Frequent events like log lines thus do not push out the change events
+// reconnecting pages need.
+type eventStream struct {
+	bc   *Broadcaster[devEvent]
+	size int // events retained per type
+
+	mu      sync.Mutex
+	lastID  uint64
+	history map[string]*eventRing // by event type
+}
+
+// eventRing is a ring buffer of events.
+type eventRing struct {
+	events []devEvent
+	next   int // index of the next write in events
+	full   bool
+}
+
+func (r *eventRing) add(ev devEvent) {
+	r.events[r.next] = ev
+	r.next = (r.next + 1) % len(r.events)
+	r.full = r.full || r.next == 0
+}
+
+// all returns the events in the ring, oldest first.
+func (r *eventRing) all() []devEvent {
+	if !r.full {
+		return r.events[:r.next]
+	}
+	return append(r.events[r.next:len(r.events):len(r.events)], r.events[:r.next]...)
+}
+
+// logEntry is the payload of eventLog events.
+type logEntry struct {
+	Source string    `json:"source"`           // build, server or watch
+	Stream string    `json:"stream,omitempty"` // stdout or stderr
+	Text   string    `json:"text"`             // a single line
+	Time   time.Time `json:"time"`
+}
+
+// newEventStream creates an eventStream that retains up to size events of
+// every type for replay.
+func newEventStream(size int) *eventStream {
+	return &eventStream{
+		bc:      NewBufferedBroadcaster[devEvent](defaultBufferSize, Disconnect),
+		size:    size,
+		history: make(map[string]*eventRing),
+	}
+}
+
+// Change publishes an eventChange event for batch.
+func (s *eventStream) Change(batch fsEventBatch) devEvent {
+	return s.Publish(eventChange, changeData{Events: batch, Time: time.Now()})
+}
+
+// Publish creates an event of type typ, assigns an ID to it, records it in the
+// history and sends it to every listener.
+func (s *eventStream) Publish(typ string, data any) devEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastID++
+	ev := devEvent{
+		ID:   s.lastID,
+		Type: typ,
+		Data: data,
+		Time: time.Now(),
+	}
+
+	if s.size > 0 {
+		r := s.history[typ]
+		if r == nil {
+			r = &eventRing{events: make([]devEvent, s.size)}
+			s.history[typ] = r
+		}
+		r.add(ev)
+	}
+
+	s.bc.Broadcast(ev)
+	return ev
+}
+
+// Subscribe registers a new listener for the given event types. When no types
+// are given the listener receives every event. Events retained in the history
+// with an ID greater than lastID are returned for replay. No event is lost or
+// duplicated between the replayed events and the ones received on the
+// channel.
+func (s *eventStream) Subscribe(lastID uint64, types ...string) ([]devEvent, <-chan devEvent, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var filter func(devEvent) bool
+	if len(types) > 0 {
+		filter = func(ev devEvent) bool {
+			return slices.Contains(types, ev.Type)
+		}
+	}
+
+	var replay []devEvent
+	for _, ev := range s.retained(types...) {
+		if ev.ID > lastID {
+			replay = append(replay, ev)
+		}
+	}
+
+	ch, remove := s.bc.AddFilteredListener(filter)
+	return replay, ch, remove
+}
+
+// History returns the retained events of the given types, oldest first. When
+// no types are given every retained event is returned.
+func (s *eventStream) History(types ...string) []devEvent {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.retained(types...)
+}
+
+// retained returns the events of the given types in the history, or every
+// event if no types are given, oldest first. s.mu must be held.
+func (s *eventStream) retained(types ...string) []devEvent {
+	var events []devEvent
+	for typ, r := range s.history {
+		if len(types) == 0 || slices.Contains(types, typ) {
+			events = append(events, r.all()...)
+		}
+	}
+	slices.SortFunc(events, func(a, b devEvent) int {
+		return cmp.Compare(a.ID, b.ID)
+	})
+	return events
+}
+
+// Shutdown publishes an eventShutdown event and disconnects every listener
+// after it received the event. Subscribing after Shutdown returns a closed
+// channel.
+func (s *eventStream) Shutdown() {
+	s.Publish(eventShutdown, struct{}{})
+	s.bc.Close()
+}
+
+// Listeners returns the number of subscribers.
+func (s *eventStream) Listeners() int {
+	return s.bc.Len()
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEventStream_Publish(t *testing.T) {
+	s := newEventStream(10)
+
+	for i := 1; i <= 3; i++ {
+		ev := s.Change(fsEventBatch{})
+		if ev.ID != uint64(i) {
+			t.Errorf("unexpected event ID\nwant: %d\ngot:  %d", i, ev.ID)
+		}
+	}
+}
+
+func TestEventStream_Replay(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		published int
+		lastID    uint64
+		want      []uint64
+	}{
+		{"No history", 0, 3, 0, nil},
+		{"Everything", 5, 3, 0, []uint64{1, 2, 3}},
+		{"Since last ID", 5, 3, 1, []uint64{2, 3}},
+		{"Up to date", 5, 3, 3, nil},
+		{"Wrapped ring", 3, 5, 0, []uint64{3, 4, 5}},
+		{"Wrapped ring since last ID", 3, 5, 3, []uint64{4, 5}},
+		{"Exactly full", 3, 3, 0, []uint64{1, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newEventStream(tt.size)
+			for range tt.published {
+				s.Change(fsEventBatch{})
+			}
+
+			replay, _, remove := s.Subscribe(tt.lastID)
+			defer remove()
+
+			var got []uint64
+			for _, ev := range replay {
+				got = append(got, ev.ID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected replayed IDs\nwant: %v\ngot:  %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestEventStream_RetainPerType(t *testing.T) {
+	s := newEventStream(3)
+	change := s.Change(fsEventBatch{})
+	for range 10 {
+		s.Publish(eventLog, logEntry{Text: "line"})
+	}
+
+	// Log lines do not push out the change event.
+	replay, _, remove := s.Subscribe(0, eventChange)
+	defer remove()
+	if len(replay) != 1 || replay[0].ID != change.ID {
+		t.Errorf("unexpected replay: %+v", replay)
+	}
+
+	var ids []uint64
+	for _, ev := range s.History() {
+		ids = append(ids, ev.ID)
+	}
+	if want := []uint64{1, 9, 10, 11}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("unexpected history\nwant: %v\ngot:  %v", want, ids)
+	}
+}
+
+func TestEventStream_SubscribeTypes(t *testing.T) {
+	s := newEventStream(10)
+	s.Change(fsEventBatch{})
+	s.Publish("build", nil)
+
+	replay, ch, remove := s.Subscribe(0, "build")
+	defer remove()
+
+	if len(replay) != 1 || replay[0].Type != "build" {
+		t.Errorf("unexpected replay: %+v", replay)
+	}
+
+	s.Change(fsEventBatch{})
+	s.Publish("build", nil)
+
+	select {
+	case ev := <-ch:
+		if ev.Type != "build" {
+			t.Errorf("unexpected event type\nwant: %s\ngot:  %s", "build", ev.Type)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for event")
+	}
+}
+
+func TestEventStream_Shutdown(t *testing.T) {
+	s := newEventStream(10)
+	_, ch, remove := s.Subscribe(0)
+	defer remove()
+
+	s.Shutdown()
+
+	var types []string
+	for ev := range ch {
+		types = append(types, ev.Type)
+	}
+	if !reflect.DeepEqual(types, []string{eventShutdown}) {
+		t.Errorf("unexpected events\nwant: %v\ngot:  %v", []string{eventShutdown}, types)
+	}
+}
+
+func TestWatchHandler(t *testing.T) {
+	s := newEventStream(10)
+	s.Change(fsEventBatch{{File: "/a.css", Ext: ".css"}})
+	s.Change(fsEventBatch{{File: "/b.css", Ext: ".css"}})
+
+	srv := httptest.NewServer(&watchHandler{stream: s, heartbeat: 10 * time.Millisecond})
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	req.Header.Set("Last-Event-ID", "1")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	want := []string{
+		"retry: 1000",
+		"id: 2",
+		"event: change",
+		"data: ",
+		": heartbeat",
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	for _, prefix := range want {
+		var line string
+		for line == "" && sc.Scan() {
+			line = sc.Text()
+		}
+		if !strings.HasPrefix(line, prefix) {
+			t.Fatalf("unexpected line\nwant prefix: %q\ngot:         %q", prefix, line)
+		}
+	}
+}
+
+func TestWatchHandler_InvalidLastEventID(t *testing.T) {
+	h := &watchHandler{stream: newEventStream(10)}
+
+	req := httptest.NewRequest("GET", "/_dev", nil)
+	req.Header.Set("Last-Event-ID", "nope")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unexpected status\nwant: %d\ngot:  %d", http.StatusBadRequest, rec.Code)
+	}
+}
+
+func TestWatchHandler_NewClient(t *testing.T) {
+	s := newEventStream(10)
+	s.Change(fsEventBatch{{File: "/a.css", Ext: ".css"}})
+
+	srv := httptest.NewServer(&watchHandler{stream: s, heartbeat: 10 * time.Millisecond})
+	defer srv.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", srv.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+
+	// Without Last-Event-ID the retained events are not replayed, the
+	// first thing after the retry interval is a heartbeat.
+	want := []string{"retry: 1000", ": heartbeat"}
+
+	sc := bufio.NewScanner(resp.Body)
+	for _, prefix := range want {
+		var line string
+		for line == "" && sc.Scan() {
+			line = sc.Text()
+		}
+		if !strings.HasPrefix(line, prefix) {
+			t.Fatalf("unexpected line\nwant prefix: %q\ngot:         %q", prefix, line)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"slices"
+	"strings"
+	"time"
+)
+
+// HAR 1.2, see http://www.softwareishard.com/blog/har-12-spec/. Only the
+// parts devserver records are supported.
+
+type harFile struct {
+	Log harLog `json:"log"`
+}
+
+type harLog struct {
+	Version string     `json:"version"`
+	Creator harCreator `json:"creator"`
+	Entries []harEntry `json:"entries"`
+}
+
+type harCreator struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type harEntry struct {
+	StartedDateTime time.Time   `json:"startedDateTime"`
+	Time            float64     `json:"time"` // milliseconds
+	Request         harRequest  `json:"request"`
+	Response        harResponse `json:"response"`
+	Cache           struct{}    `json:"cache"`
+	Timings         harTimings  `json:"timings"`
+}
+
+type harRequest struct {
+	Method      string         `json:"method"`
+	URL         string         `json:"url"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	QueryString []harNameValue `json:"queryString"`
+	PostData    *harPostData   `json:"postData,omitempty"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harPostData struct {
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text"`
+	// Encoding is base64 for binary bodies. It is not part of HAR 1.2,
+	// hence the underscore.
+	Encoding string `json:"_encoding,omitempty"`
+	// Truncated is set if only the beginning of the body was captured.
+	Truncated bool `json:"_truncated,omitempty"`
+}
+
+type harResponse struct {
+	Status      int            `json:"status"`
+	StatusText  string         `json:"statusText"`
+	HTTPVersion string         `json:"httpVersion"`
+	Cookies     []harNameValue `json:"cookies"`
+	Headers     []harNameValue `json:"headers"`
+	Content     harContent     `json:"content"`
+	RedirectURL string         `json:"redirectURL"`
+	HeadersSize int            `json:"headersSize"`
+	BodySize    int64          `json:"bodySize"`
+}
+
+type harContent struct {
+	Size     int64  `json:"size"`
+	MimeType string `json:"mimeType"`
+	Text     string `json:"text,omitempty"`
+	Encoding string `json:"encoding,omitempty"`
+	// Truncated is set if only the beginning of the body was captured.
+	Truncated bool `json:"_truncated,omitempty"`
+}
+
+type harNameValue struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type harTimings struct {
+	Send    float64 `json:"send"`
+	Wait    float64 `json:"wait"`
+	Receive float64 `json:"receive"`
+}
+
+// newHAR converts the recorded requests to HAR.
+func newHAR(entries []*requestEntry) harFile {
+	version := "devel"
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		version = info.Main.Version
+	}
+
+	har := harFile{Log: harLog{
+		Version: "1.2",
+		Creator: harCreator{Name: "devserver", Version: version},
+		Entries: []harEntry{},
+	}}
+	for _, e := range entries {
+		har.Log.Entries = append(har.Log.Entries, newHAREntry(e))
+	}
+	return har
+}
+
+func newHAREntry(e *requestEntry) harEntry {
+	u := &url.URL{Scheme: "http", Host: e.Host}
+	if e.TLS {
+		u.Scheme = "https"
+	}
+	if ref, err := url.Parse(e.URL); err == nil {
+		u = u.ResolveReference(ref)
+	}
+
+	req := harRequest{
+		Method:      e.Method,
+		URL:         u.String(),
+		HTTPVersion: e.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(e.RequestHeaders),
+		QueryString: []harNameValue{},
+		HeadersSize: -1,
+		BodySize:    e.RequestSize,
+	}
+	for name, values := range u.Query() {
+		for _, v := range values {
+			req.QueryString = append(req.QueryString, harNameValue{Name: name, Value: v})
+		}
+	}
+	slices.SortStableFunc(req.QueryString, func(a, b harNameValue) int { return strings.Compare(a.Name, b.Name) })
+	for _, c := range (&http.Request{Header: e.RequestHeaders}).Cookies() {
+		req.Cookies = append(req.Cookies, harNameValue{Name: c.Name, Value: c.Value})
+	}
+	if b := e.RequestBody; b != nil {
+		req.PostData = &harPostData{
+			MimeType:  e.RequestHeaders.Get("content-type"),
+			Text:      b.Text,
+			Encoding:  b.Encoding,
+			Truncated: b.Truncated,
+		}
+	}
+
+	resp := harResponse{
+		Status:      e.Status,
+		StatusText:  http.StatusText(e.Status),
+		HTTPVersion: e.Proto,
+		Cookies:     []harNameValue{},
+		Headers:     harHeaders(e.ResponseHeaders),
+		Content: harContent{
+			Size:     e.ResponseSize,
+			MimeType: e.ContentType,
+		},
+		RedirectURL: e.ResponseHeaders.Get("location"),
+		HeadersSize: -1,
+		BodySize:    e.ResponseSize,
+	}
+	for _, c := range (&http.Response{Header: e.ResponseHeaders}).Cookies() {
+		resp.Cookies = append(resp.Cookies, harNameValue{Name: c.Name, Value: c.Value})
+	}
+	if b := e.ResponseBody; b != nil {
+		resp.Content.Text = b.Text
+		resp.Content.Encoding = b.Encoding
+		resp.Content.Truncated = b.Truncated
+	}
+
+	return harEntry{
+		StartedDateTime: e.Started,
+		Time:            e.Duration,
+		Request:         req,
+		Response:        resp,
+		Timings:         harTimings{Wait: e.Duration},
+	}
+}
+
+// harHeaders converts h to HAR headers sorted by name.
+func harHeaders(h http.Header) []harNameValue {
+	headers := []harNameValue{}
+	for name, values := range h {
+		for _, v := range values {
+			headers = append(headers, harNameValue{Name: name, Value: v})
+		}
+	}
+	slices.SortStableFunc(headers, func(a, b harNameValue) int { return strings.Compare(a.Name, b.Name) })
+	return headers
+}
+
+// httpHeader converts HAR headers back to an http.Header.
+func httpHeader(headers []harNameValue) http.Header {
+	h := make(http.Header, len(headers))
+	for _, nv := range headers {
+		h.Add(nv.Name, nv.Value)
+	}
+	return h
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNewHAR(t *testing.T) {
+	e := &requestEntry{
+		requestSummary: requestSummary{
+			Method:       "POST",
+			Host:         "localhost:8080",
+			URL:          "/items?b=2&a=1",
+			Proto:        "HTTP/1.1",
+			Status:       http.StatusFound,
+			Started:      time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+			Duration:     12.5,
+			RequestSize:  6,
+			ResponseSize: 2,
+			ContentType:  "application/octet-stream",
+		},
+		RequestHeaders: http.Header{
+			"Content-Type": {"application/x-www-form-urlencoded"},
+			"Cookie":       {"session=abc"},
+		},
+		ResponseHeaders: http.Header{
+			"Location":   {"/items/1"},
+			"Set-Cookie": {"flash=saved; Path=/"},
+		},
+		RequestBody:  newCapturedBody([]byte("name=x"), false),
+		ResponseBody: newCapturedBody([]byte{0xff, 0x00}, false),
+	}
+
+	har := newHAR([]*requestEntry{e})
+	if har.Log.Version != "1.2" || len(har.Log.Entries) != 1 {
+		t.Fatalf("unexpected HAR log: %+v", har.Log)
+	}
+	got := har.Log.Entries[0]
+
+	if got.Request.URL != "http://localhost:8080/items?b=2&a=1" {
+		t.Errorf("unexpected URL: %s", got.Request.URL)
+	}
+	wantQuery := []harNameValue{{"a", "1"}, {"b", "2"}}
+	if !reflect.DeepEqual(got.Request.QueryString, wantQuery) {
+		t.Errorf("unexpected query string\nwant: %v\ngot:  %v", wantQuery, got.Request.QueryString)
+	}
+	wantCookies := []harNameValue{{"session", "abc"}}
+	if !reflect.DeepEqual(got.Request.Cookies, wantCookies) {
+		t.Errorf("unexpected request cookies\nwant: %v\ngot:  %v", wantCookies, got.Request.Cookies)
+	}
+	if pd := got.Request.PostData; pd == nil || pd.Text != "name=x" || pd.MimeType != "application/x-www-form-urlencoded" {
+		t.Errorf("unexpected post data: %+v", pd)
+	}
+	if got.Response.RedirectURL != "/items/1" || got.Response.StatusText != "Found" {
+		t.Errorf("unexpected response: %+v", got.Response)
+	}
+	if c := got.Response.Content; c.Encoding != "base64" || c.Text != "/wA=" || c.Size != 2 {
+		t.Errorf("unexpected content: %+v", c)
+	}
+	if !reflect.DeepEqual(httpHeader(got.Response.Headers), e.ResponseHeaders) {
+		t.Errorf("headers do not round-trip\nwant: %v\ngot:  %v", e.ResponseHeaders, httpHeader(got.Response.Headers))
+	}
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// hmrRuntimePath is the URL of the hot module replacement runtime.
+const hmrRuntimePath = "/_dev/hmr.js"
+
+// maxModuleSize is the size limit for JavaScript modules handled by the
+// moduleGraph. Larger modules are passed through untouched.
+const maxModuleSize = 4 << 20 // 4M
+
+//go:embed hmr.js
+var hmrRuntime []byte
+
+// hmrPrelude is prepended to modules using the import.meta.hot API.
+const hmrPrelude = `import { createHot as __devserverCreateHot } from "` + hmrRuntimePath + `"; import.meta.hot = __devserverCreateHot(import.meta.url);` + "\n"
+
+// moduleGraph tracks the import graph of the JavaScript modules served
+// through the proxy. It is used to find the modules that have to be
+// re-imported when a module changes.
+type moduleGraph struct {
+	mu      sync.Mutex
+	modules map[string]*jsModule // keyed by URL path
+}
+
+type jsModule struct {
+	imports []string // URL paths of the imported modules
+	accepts bool     // the module calls import.meta.hot.accept
+	version int      // incremented on every update
+}
+
+func newModuleGraph() *moduleGraph {
+	return &moduleGraph{
+		modules: make(map[string]*jsModule),
+	}
+}
+
+// ModifyResponse records the JavaScript module in resp and rewrites it for
+// hot module replacement. Imports of local modules get a version query
+// parameter so that re-importing a module also loads the updated version of
+// its dependencies.
+func (g *moduleGraph) ModifyResponse(resp *http.Response) error {
+	if resp.StatusCode != http.StatusOK || !isJavaScript(resp.Header.Get("content-type")) {
+		return nil
+	}
+
+	src, err := io.ReadAll(io.LimitReader(resp.Body, maxModuleSize+1))
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("hmr: error reading module: %w", err)
+	}
+
+	if len(src) <= maxModuleSize {
+		src = g.Record(resp.Request.URL.Path, src)
+	} else {
+		log.Printf("hmr: %s is too large, skipping", resp.Request.URL.Path)
+	}
+
+	// Let the reverse proxy figure out the Content-Length
+	resp.Header.Del("content-length")
+	resp.Header.Set("cache-control", "no-cache")
+	resp.Body = io.NopCloser(bytes.NewReader(src))
+	return nil
+}
+
+// Record parses the imports of the module served at p and returns src with
+// rewritten imports.
+func (g *moduleGraph) Record(p string, src []byte) []byte {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	m := g.module(p)
+	m.imports = m.imports[:0]
+	m.accepts = bytes.Contains(src, []byte("import.meta.hot.accept("))
+
+	var (
+		out  []byte
+		last int
+	)
+	for _, loc := range jsImportRe.FindAllSubmatchIndex(src, -1) {
+		// loc[6]:loc[7] is the module specifier
+		spec := string(src[loc[6]:loc[7]])
+		dep, ok := resolveModule(p, spec)
+		if !ok {
+			continue
+		}
+		m.imports = append(m.imports, dep)
+
+		if v := g.module(dep).version; v > 0 {
+			spec, _, _ = strings.Cut(spec, "?")
+			out = append(out, src[last:loc[6]]...)
+			out = fmt.Appendf(out, "%s?v=%d", spec, v)
+			last = loc[7]
+		}
+	}
+	src = append(out, src[last:]...)
+
+	if bytes.Contains(src, []byte("import.meta.hot")) {
+		src = append([]byte(hmrPrelude), src...)
+	}
+	return src
+}
+
+// Update marks the module at p as changed. It returns the URL paths of the
+// modules that accept the update. ok is false if the update cannot be
+// applied without reloading the page.
+func (g *moduleGraph) Update(p string) (boundaries []string, ok bool) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if _, found := g.modules[p]; !found {
+		return nil, false
+	}
+
+	importers := make(map[string][]string)
+	for mp, m := range g.modules {
+		for _, dep := range m.imports {
+			importers[dep] = append(importers[dep], mp)
+		}
+	}
+
+	seen := map[string]bool{p: true}
+	queue := []string{p}
+	for len(queue) > 0 {
+		mp := queue[0]
+		queue = queue[1:]
+
+		// Bump the version of every module between the changed module and
+		// the boundaries so that re-importing the boundaries loads fresh
+		// copies.
+		m := g.module(mp)
+		m.version++
+		if m.accepts {
+			boundaries = append(boundaries, mp)
+			continue
+		}
+
+		if len(importers[mp]) == 0 {
+			// Reached an entry point without finding a module accepting
+			// the update.
+			return nil, false
+		}
+		for _, imp := range importers[mp] {
+			if !seen[imp] {
+				seen[imp] = true
+				queue = append(queue, imp)
+			}
+		}
+	}
+
+	return boundaries, true
+}
+
+// module returns the module at p, creating it if necessary. g.mu must be held.
+func (g *moduleGraph) module(p string) *jsModule {
+	m, ok := g.modules[p]
+	if !ok {
+		m = &jsModule{}
+		g.modules[p] = m
+	}
+	return m
+}
+
+// jsImportRe matches static imports, re-exports and dynamic imports with a
+// string literal specifier.
+var jsImportRe = regexp.MustCompile(`(\bimport\s*(?:[\w$*{}\s,]+?\s*from\s*)?|\bexport\s*[\w$*{}\s,]+?\s*from\s*|\bimport\s*\(\s*)(["'])([^"'\n]+)["']`)
+
+// resolveModule resolves spec imported by the module at p to a URL path. Only
+// relative and absolute specifiers are resolved, bare specifiers and URLs
+// are not.
+func resolveModule(p, spec string) (string, bool) {
+	spec, _, _ = strings.Cut(spec, "?")
+	spec, _, _ = strings.Cut(spec, "#")
+
+	switch {
+	case strings.HasPrefix(spec, "/") && !strings.HasPrefix(spec, "//"):
+		return path.Clean(spec), true
+	case strings.HasPrefix(spec, "./"), strings.HasPrefix(spec, "../"):
+		return path.Join(path.Dir(p), spec), true
+	default:
+		return "", false
+	}
+}
+
+func isJavaScript(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mt == "text/javascript" || mt == "application/javascript"
+}
@@ -0,0 +1,67 @@
+// hmr.js is the hot module replacement runtime served by devserver at
+// /_dev/hmr.js. Modules using import.meta.hot get it via a prelude added by
+// devserver:
+//
+//	if (import.meta.hot) {
+//		import.meta.hot.accept((mod) => { ... });
+//		import.meta.hot.dispose((data) => { ... });
+//	}
+
+// registry maps module URL paths to their hot context.
+const registry = new Map();
+
+const key = (url) => new URL(url, location.href).pathname;
+
+// createHot returns the hot context of the module at url.
+export const createHot = (url) => {
+	const id = key(url);
+	const hot = {
+		// data is passed from the disposed module to its replacement.
+		data: registry.get(id)?.data ?? {},
+		acceptCallbacks: [],
+		disposeCallbacks: [],
+
+		// accept marks the module as able to replace itself. cb is called
+		// with the new module after it was imported.
+		accept(cb = () => {}) {
+			this.acceptCallbacks.push(cb);
+		},
+
+		// dispose registers cb to clean up side effects of the module before
+		// it is replaced. cb can store state for the new module in data.
+		dispose(cb) {
+			this.disposeCallbacks.push(cb);
+		},
+
+		// invalidate gives up on hot replacement and reloads the page.
+		invalidate() {
+			location.reload();
+		},
+	};
+	registry.set(id, hot);
+	return hot;
+};
+
+// apply re-imports the boundary modules. It returns false if a module does
+// not accept updates and the page has to be reloaded.
+export const apply = async (boundaries) => {
+	if (!boundaries.every((id) => registry.get(id)?.acceptCallbacks.length > 0)) {
+		return false;
+	}
+
+	for (const id of boundaries) {
+		const hot = registry.get(id);
+		const data = {};
+		for (const cb of hot.disposeCallbacks) {
+			await cb(data);
+		}
+		hot.data = data;
+
+		const mod = await import(`${id}?t=${Date.now()}`);
+		for (const cb of hot.acceptCallbacks) {
+			cb(mod);
+		}
+		console.info("hot updated module", id);
+	}
+	return true;
+};
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestModuleGraph_Record(t *testing.T) {
+	src := `import { a } from "./a.js";
+import * as b from '../lib/b.js';
+import "/js/c.js";
+export { d } from "./d.js";
+const e = await import("./e.js");
+import lit from "lit";
+import x from "https://example.com/x.js";
+`
+	g := newModuleGraph()
+	out := g.Record("/js/app/main.js", []byte(src))
+
+	if string(out) != src {
+		t.Errorf("expected source to be unchanged\nwant: %s\ngot:  %s", src, out)
+	}
+
+	want := []string{"/js/app/a.js", "/js/lib/b.js", "/js/c.js", "/js/app/d.js", "/js/app/e.js"}
+	if got := g.modules["/js/app/main.js"].imports; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected imports\nwant: %q\ngot:  %q", want, got)
+	}
+}
+
+func TestModuleGraph_RecordVersions(t *testing.T) {
+	g := newModuleGraph()
+	g.Record("/main.js", []byte(`import "./a.js";`))
+	g.Record("/a.js", []byte(`import.meta.hot.accept();`))
+
+	if _, ok := g.Update("/a.js"); !ok {
+		t.Fatal("expected update to be accepted")
+	}
+
+	got := string(g.Record("/main.js", []byte(`import "./a.js"; import "./b.js?x=1";`)))
+	want := `import "./a.js?v=1"; import "./b.js?x=1";`
+	if got != want {
+		t.Errorf("unexpected source\nwant: %s\ngot:  %s", want, got)
+	}
+}
+
+func TestModuleGraph_RecordPrelude(t *testing.T) {
+	g := newModuleGraph()
+
+	src := "if (import.meta.hot) { import.meta.hot.accept(); }"
+	got := string(g.Record("/a.js", []byte(src)))
+	if got != hmrPrelude+src {
+		t.Errorf("expected prelude to be added\ngot: %s", got)
+	}
+	if !g.modules["/a.js"].accepts {
+		t.Error("expected module to accept updates")
+	}
+
+	src = "console.log('hello')"
+	if got := string(g.Record("/b.js", []byte(src))); got != src {
+		t.Errorf("expected source to be unchanged\ngot: %s", got)
+	}
+}
+
+func TestModuleGraph_Update(t *testing.T) {
+	// main.js -> app.js (accepts) -> util.js
+	//         -> nav.js -> util.js
+	//         -> footer.js
+	g := newModuleGraph()
+	g.Record("/main.js", []byte(`import "./app.js"; import "./nav.js"; import "./footer.js";`))
+	g.Record("/app.js", []byte(`import "./util.js"; import.meta.hot.accept();`))
+	g.Record("/nav.js", []byte(`import "./util.js"; import.meta.hot.accept();`))
+	g.Record("/util.js", []byte(`export const x = 1;`))
+	g.Record("/footer.js", []byte(`export const y = 1;`))
+
+	tests := []struct {
+		name       string
+		module     string
+		boundaries []string
+		ok         bool
+	}{
+		{"Self accepting", "/app.js", []string{"/app.js"}, true},
+		{"Accepted by importers", "/util.js", []string{"/app.js", "/nav.js"}, true},
+		{"Bubbles up to entry", "/footer.js", nil, false},
+		{"Entry", "/main.js", nil, false},
+		{"Unknown", "/unknown.js", nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			boundaries, ok := g.Update(tt.module)
+			slices.Sort(boundaries)
+			if ok != tt.ok {
+				t.Errorf("unexpected ok\nwant: %t\ngot:  %t", tt.ok, ok)
+			}
+			if !reflect.DeepEqual(boundaries, tt.boundaries) {
+				t.Errorf("unexpected boundaries\nwant: %q\ngot:  %q", tt.boundaries, boundaries)
+			}
+		})
+	}
+}
+
+func TestModuleGraph_ModifyResponse(t *testing.T) {
+	tests := []struct {
+		contentType string
+		status      int
+		rewritten   bool
+	}{
+		{"text/javascript; charset=utf-8", http.StatusOK, true},
+		{"application/javascript", http.StatusOK, true},
+		{"text/html", http.StatusOK, false},
+		{"text/javascript", http.StatusNotModified, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.contentType, func(t *testing.T) {
+			src := "import.meta.hot.accept();"
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{"Content-Type": {tt.contentType}},
+				Body:       io.NopCloser(strings.NewReader(src)),
+				Request:    &http.Request{URL: &url.URL{Path: "/a.js"}},
+			}
+
+			if err := newModuleGraph().ModifyResponse(resp); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			b, _ := io.ReadAll(resp.Body)
+			if got := strings.HasPrefix(string(b), hmrPrelude); got != tt.rewritten {
+				t.Errorf("unexpected rewrite\nwant: %t\ngot:  %t", tt.rewritten, got)
+			}
+		})
+	}
+}
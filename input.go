@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+const inputHelp = `Keyboard shortcuts:
+  Enter, b  rebuild and restart
+  r         restart without rebuilding
+  l         reload browsers
+  c         clear screen
+  o         open browser
+  p         pause/resume watching files
+  q         quit
+  ?         show this help
+`
+
+// inputActions are the actions triggered by keyboard shortcuts.
+type inputActions struct {
+	restart chan<- restartRequest
+	reload  func()
+	open    func()
+	pause   func() bool // toggles pause and returns whether watching is paused
+	quit    func()
+}
+
+// handleInput reads keyboard shortcuts from in. When keys is true, i.e. the
+// terminal is in cbreak mode, single key presses are handled right away.
+// Otherwise every line is handled as a shortcut, an empty line rebuilds and
+// restarts.
+func handleInput(in io.Reader, keys bool, a inputActions) {
+	if !keys {
+		fmt.Println("Hit Enter to rebuild and restart, type ? and Enter for help")
+		s := bufio.NewScanner(in)
+		for s.Scan() {
+			if !a.handle(strings.TrimSpace(s.Text())) {
+				return
+			}
+		}
+		return
+	}
+
+	fmt.Println("Hit Enter to rebuild and restart, ? for help")
+	r := bufio.NewReader(in)
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err != io.EOF {
+				fmt.Printf("input error: %v\n", err)
+			}
+			return
+		}
+		if !a.handle(string(b)) {
+			return
+		}
+	}
+}
+
+// handle runs the action of key. It returns false if no further input
+// should be handled.
+func (a inputActions) handle(key string) bool {
+	switch key {
+	case "", "\n", "\r", "b":
+		a.sendRestart(restartRequest{build: true})
+	case "r":
+		a.sendRestart(restartRequest{build: false})
+	case "l":
+		infof("Reloading browsers")
+		a.reload()
+	case "c":
+		fmt.Print("\033[H\033[2J")
+	case "o":
+		a.open()
+	case "p":
+		if a.pause() {
+			infof("Paused watching files")
+		} else {
+			infof("Resumed watching files")
+		}
+	case "q":
+		infof("Quitting...")
+		a.quit()
+		return false
+	case "?", "h":
+		fmt.Print(inputHelp)
+	}
+	return true
+}
+
+// sendRestart sends req to rerun without blocking input handling while a
+// build is in progress. Presses while a restart is pending are dropped, the
+// pending restart picks up the latest changes anyway.
+func (a inputActions) sendRestart(req restartRequest) {
+	select {
+	case a.restart <- req:
+	default:
+	}
+}
+
+// openBrowser opens url in the default browser.
+func openBrowser(url string) {
+	name := "xdg-open"
+	if runtime.GOOS == "darwin" {
+		name = "open"
+	}
+	if err := exec.Command(name, url).Start(); err != nil {
+		fmt.Printf("open browser error: %v\n", err)
+	}
+}
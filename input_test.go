@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHandleInput_LineMode(t *testing.T) {
+	restart := make(chan restartRequest, 10)
+	var reloads, quits int
+	paused := false
+
+	a := inputActions{
+		restart: restart,
+		reload:  func() { reloads++ },
+		open:    func() {},
+		pause: func() bool {
+			paused = !paused
+			return paused
+		},
+		quit: func() { quits++ },
+	}
+
+	handleInput(strings.NewReader("b\nr\n\nl\np\nq\nl\n"), false, a)
+
+	var builds, restarts int
+	for range 3 {
+		select {
+		case req := <-restart:
+			if req.build {
+				builds++
+			} else {
+				restarts++
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Timeout waiting for restart request")
+		}
+	}
+
+	if builds != 2 || restarts != 1 {
+		t.Errorf("unexpected restart requests\nwant: 2 builds, 1 restart\ngot:  %d builds, %d restarts", builds, restarts)
+	}
+	if reloads != 1 {
+		t.Errorf("expected input after quit to be ignored, got %d reloads", reloads)
+	}
+	if !paused {
+		t.Error("expected watching to be paused")
+	}
+	if quits != 1 {
+		t.Errorf("expected 1 quit, got %d", quits)
+	}
+}
+
+func TestHandleInput_CoalesceRestarts(t *testing.T) {
+	restart := make(chan restartRequest, 1)
+	a := inputActions{
+		restart: restart,
+		quit:    func() {},
+	}
+
+	// Nobody receives the requests, as if a build were in progress.
+	handleInput(strings.NewReader("b\nb\nr\nq\n"), false, a)
+
+	if n := len(restart); n != 1 {
+		t.Errorf("expected 1 pending restart request, got %d", n)
+	}
+}
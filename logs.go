@@ -0,0 +1,242 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// Log sources.
+const (
+	sourceBuild  = "build"
+	sourceServer = "server"
+	sourceWatch  = "watch"
+)
+
+// Output streams of a log source.
+const (
+	streamStdout = "stdout"
+	streamStderr = "stderr"
+)
+
+// logBufferSize is the number of lines kept per source.
+const logBufferSize = 1000
+
+var (
+	sourceColors = map[string]*color.Color{
+		sourceBuild:  color.New(color.FgYellow),
+		sourceServer: color.New(color.FgCyan),
+		sourceWatch:  color.New(color.FgMagenta),
+	}
+	stderrColor = color.New(color.FgRed)
+)
+
+// logLine is a line of output kept by logMux. ID is the ID of the eventLog
+// event the line was published as.
+type logLine struct {
+	ID uint64
+	logEntry
+}
+
+// logMux multiplexes the output of the build, the server and the file
+// watcher. Every line is written to out prefixed with its source, published
+// as an eventLog event and kept in a per source ring buffer.
+type logMux struct {
+	out        io.Writer
+	stream     *eventStream
+	timestamps bool // prefix lines with the time
+	// format formats the text of a line for out and decides whether it is
+	// shown at all, e.g. slogView.Format. Lines are kept and published
+	// unchanged. nil shows every line as is. Set before use.
+	format func(logEntry) (string, bool)
+
+	mu      sync.Mutex
+	buffers map[string]*logRing
+	taps    []func(logEntry)
+}
+
+func newLogMux(out io.Writer, stream *eventStream, timestamps bool) *logMux {
+	return &logMux{
+		out:        out,
+		stream:     stream,
+		timestamps: timestamps,
+		buffers:    make(map[string]*logRing),
+	}
+}
+
+// Writer returns a writer for the output of source. stream is streamStdout
+// or streamStderr. Incomplete lines are buffered until the next newline or Close.
+func (m *logMux) Writer(source, stream string) io.WriteCloser {
+	return &lineWriter{mux: m, source: source, stream: stream}
+}
+
+// Tap registers f to be called with every line, in order. f is called while
+// the logMux is locked, it must not block or call methods of the logMux.
+func (m *logMux) Tap(f func(logEntry)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.taps = append(m.taps, f)
+}
+
+// Print logs a single line of text, e.g. a message about source.
+func (m *logMux) Print(source, stream, text string) {
+	for line := range Lines(text) {
+		m.line(logEntry{Source: source, Stream: stream, Text: line, Time: time.Now()})
+	}
+}
+
+func (m *logMux) line(e logEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ev := m.stream.Publish(eventLog, e)
+
+	buf, ok := m.buffers[e.Source]
+	if !ok {
+		buf = newLogRing(logBufferSize)
+		m.buffers[e.Source] = buf
+	}
+	buf.Add(logLine{ID: ev.ID, logEntry: e})
+
+	text, show := e.Text, true
+	if m.format != nil {
+		text, show = m.format(e)
+	}
+	if show {
+		// A single write per line keeps lines from interleaving with
+		// other output, see infof.
+		var b strings.Builder
+		if m.timestamps {
+			b.WriteString(e.Time.Format("15:04:05.000 "))
+		}
+		b.WriteString(colorPrefix(e))
+		b.WriteString(text)
+		b.WriteByte('\n')
+		io.WriteString(m.out, b.String())
+	}
+
+	for _, f := range m.taps {
+		f(e)
+	}
+}
+
+// Lines returns the last n lines of the given sources, oldest first. All
+// sources are returned when none is given, n <= 0 returns every kept line.
+func (m *logMux) Lines(n int, sources ...string) []logLine {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var lines []logLine
+	for source, buf := range m.buffers {
+		if len(sources) == 0 || slices.Contains(sources, source) {
+			lines = append(lines, buf.Lines()...)
+		}
+	}
+	// IDs are assigned in order, sorting by ID merges the sources.
+	slices.SortFunc(lines, func(a, b logLine) int { return cmp.Compare(a.ID, b.ID) })
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// logPrefix returns the prefix of the lines of e when printed, e.g.
+// "[server] " or "[server:err] " for stderr.
+func logPrefix(e logEntry) string {
+	if e.Stream == streamStderr {
+		return "[" + e.Source + ":err] "
+	}
+	return "[" + e.Source + "] "
+}
+
+func colorPrefix(e logEntry) string {
+	c, ok := sourceColors[e.Source]
+	if !ok {
+		c = bold
+	}
+	if e.Stream == streamStderr {
+		return c.Sprint("["+e.Source) + stderrColor.Sprint(":err") + c.Sprint("] ")
+	}
+	return c.Sprint(logPrefix(e))
+}
+
+// lineWriter splits the output written to it into lines for logMux.
+type lineWriter struct {
+	mux    *logMux
+	source string
+	stream string
+
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+	if len(w.buf) == 0 {
+		w.buf = nil
+	}
+	return len(p), nil
+}
+
+// Close writes the last line if it is not terminated by a newline.
+func (w *lineWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.buf) > 0 {
+		w.emit(w.buf)
+		w.buf = nil
+	}
+	return nil
+}
+
+func (w *lineWriter) emit(line []byte) {
+	w.mux.line(logEntry{
+		Source: w.source,
+		Stream: w.stream,
+		Text:   string(bytes.TrimSuffix(line, []byte("\r"))),
+		Time:   time.Now(),
+	})
+}
+
+// logRing is a ring buffer of log lines.
+type logRing struct {
+	lines []logLine
+	next  int // index of the next write in lines
+	full  bool
+}
+
+func newLogRing(size int) *logRing {
+	return &logRing{lines: make([]logLine, size)}
+}
+
+func (r *logRing) Add(l logLine) {
+	r.lines[r.next] = l
+	r.next = (r.next + 1) % len(r.lines)
+	r.full = r.full || r.next == 0
+}
+
+// Lines returns a copy of the lines, oldest first.
+func (r *logRing) Lines() []logLine {
+	if !r.full {
+		return append([]logLine(nil), r.lines[:r.next]...)
+	}
+	return append(append([]logLine(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+}
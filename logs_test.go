@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestLogMux_Writer(t *testing.T) {
+	color.NoColor = true
+
+	var out bytes.Buffer
+	m := newLogMux(&out, newEventStream(10), false)
+
+	stdout := m.Writer(sourceServer, streamStdout)
+	stderr := m.Writer(sourceServer, streamStderr)
+	io.WriteString(stdout, "listening on :8080\nhand")
+	io.WriteString(stderr, "oops\r\n")
+	io.WriteString(stdout, "led request\nno newline")
+	stdout.Close()
+
+	want := "[server] listening on :8080\n" +
+		"[server:err] oops\n" +
+		"[server] handled request\n" +
+		"[server] no newline\n"
+	if out.String() != want {
+		t.Errorf("unexpected output\nwant: %q\ngot:  %q", want, out.String())
+	}
+}
+
+func TestLogMux_Lines(t *testing.T) {
+	s := newEventStream(10)
+	m := newLogMux(io.Discard, s, false)
+	m.Print(sourceBuild, streamStdout, "b1\nb2")
+	m.Print(sourceServer, streamStdout, "s1")
+	m.Print(sourceBuild, streamStderr, "b3")
+
+	texts := func(lines []logLine) []string {
+		var texts []string
+		for _, l := range lines {
+			texts = append(texts, l.Text)
+		}
+		return texts
+	}
+
+	tests := []struct {
+		name    string
+		n       int
+		sources []string
+		want    []string
+	}{
+		{"All", 0, nil, []string{"b1", "b2", "s1", "b3"}},
+		{"Last n", 2, nil, []string{"s1", "b3"}},
+		{"Source", 0, []string{sourceBuild}, []string{"b1", "b2", "b3"}},
+		{"Unknown source", 0, []string{"nope"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := texts(m.Lines(tt.n, tt.sources...)); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected lines\nwant: %q\ngot:  %q", tt.want, got)
+			}
+		})
+	}
+
+	// Lines are published on the event stream, the IDs match.
+	history := s.History(eventLog)
+	lines := m.Lines(0)
+	if len(history) != len(lines) || history[len(history)-1].ID != lines[len(lines)-1].ID {
+		t.Errorf("lines do not match the published events\nevents: %+v\nlines:  %+v", history, lines)
+	}
+}
+
+func TestLogRing(t *testing.T) {
+	r := newLogRing(3)
+	for _, text := range strings.Fields("a b c d e") {
+		r.Add(logLine{logEntry: logEntry{Text: text}})
+	}
+
+	var got []string
+	for _, l := range r.Lines() {
+		got = append(got, l.Text)
+	}
+	if want := []string{"c", "d", "e"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected lines\nwant: %q\ngot:  %q", want, got)
+	}
+}
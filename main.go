@@ -1,8 +1,8 @@
 package main
 
 import (
-	"bufio"
 	"context"
+	"crypto/tls"
 	"errors"
 	"flag"
 	"fmt"
@@ -10,10 +10,15 @@ import (
 	"math"
 	"math/rand/v2"
 	"net"
-	"net/url"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -21,25 +26,64 @@ import (
 )
 
 const usage = `Usage: %s [options] <serverCmd>
+       %[1]s ctl <command> [options]
 
 Supported placeholder in serverCmd:
   {} is replaced by host:port
   {host} is replaced by host
   {port} is replaced by port
+  {socket} is replaced by the socket path of a unix:// -upstream
 
 `
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "ctl" {
+		os.Exit(runCtl(os.Args[2:], os.Stdout, os.Stderr))
+	}
+
+	os.Exit(run())
+}
+
+// run runs devserver and returns the exit code.
+func run() int {
 	flag.Usage = func() {
 		fmt.Fprintf(flag.CommandLine.Output(), usage, os.Args[0])
 		flag.PrintDefaults()
 	}
 	port := flag.String("port", "18080", "upstream port")
+	upstreamAddr := flag.String("upstream", "", "upstream address, host:port or unix:///path/to.sock, overrides -port")
 	addr := flag.String("addr", "127.0.0.1:8080", "devserver bind address")
 	liveReload := flag.Bool("live-reload", true, "enable/disable automatic reload via server sent events")
 	restart := flag.Bool("restart", true, "enable/disable automatic restart on go file change")
 	buildCmd := flag.String("build-cmd", "make", "command to run to build the server")
 	webRoot := flag.String("web-root", "", "web root directory, reported file paths are relative to this directory")
+	staticPrefix := flag.String("static-prefix", "", "serve files under -web-root for URL paths starting with this prefix, e.g. /static/, without the server")
+	controlSocket := flag.String("control-socket", "", "serve the control API on this Unix domain socket too")
+	toolbar := flag.Bool("toolbar", true, "show the devserver status toolbar in the browser")
+	morph := flag.Bool("morph", false, "update the DOM in place instead of reloading the page on template changes and restarts")
+	logTime := flag.Bool("log-time", false, "prefix build, server and watcher output with the time")
+	errorOverlay := flag.Bool("error-overlay", false, "show server panics and errors in an overlay in the browser, not only in the console")
+	var errorPatterns errorPatternFlag
+	flag.Var(&errorPatterns, "error-pattern", "regexp matching error lines in the server output, replaces the default patterns matching level=error logs (repeatable)")
+	prettyLogs := flag.Bool("pretty-logs", false, "pretty-print JSON log lines of the server, e.g. the output of log/slog's JSONHandler")
+	logLevel := flag.String("log-level", "", "hide JSON log lines of the server below this level: debug, info, warn or error")
+	var logFilters logFilterFlag
+	flag.Var(&logFilters, "log-filter", "only show JSON log lines of the server with this attribute, e.g. 'request.method=POST' (repeatable)")
+	requestLogSize := flag.Int("request-log-size", 500, "number of proxied requests kept for the request log at /_dev/requests")
+	requestLogBody := flag.Int("request-log-body", 0, "capture up to this many bytes of request and response bodies in the request log")
+	latency := flag.Duration("latency", 0, "delay proxied requests, simulates a slow network")
+	jitter := flag.Duration("jitter", 0, "add a random delay of up to this duration to proxied requests")
+	bandwidth := flag.Int("bandwidth", 0, "throttle proxied responses to this many KiB per second")
+	var faults faultFlag
+	flag.Var(&faults, "fault", "fail proxied requests: [path=]kind@rate, kind is a status code, reset or timeout, e.g. '/api/**=503@0.1' (repeatable)")
+	useTLS := flag.Bool("tls", false, "serve HTTPS with a certificate issued by a local CA, which is created on first use")
+	tlsHostNames := flag.String("tls-hosts", "", "comma separated host names and IP addresses the certificate is valid for besides localhost")
+	httpRedirect := flag.String("http-redirect", "", "with -tls, redirect plain HTTP requests on this address to HTTPS, e.g. 127.0.0.1:8081")
+	h2c := flag.Bool("h2c", false, "accept HTTP/2 without TLS (h2c with prior knowledge), HTTP/2 is always enabled with -tls")
+	upstreamH2C := flag.Bool("upstream-h2c", false, "talk HTTP/2 without TLS (h2c with prior knowledge) to the server")
+	mocksFile := flag.String("mocks", "", "JSON file with stub routes served instead of proxying, reloaded on change")
+	var cssMap cssMapFlag
+	flag.Var(&cssMap, "css-map", "map source files to the stylesheet they are compiled to, e.g. 'scss/**/*.scss=/css/app.css' (repeatable)")
 	flag.Parse()
 
 	serverCmd := flag.Arg(0)
@@ -47,38 +91,379 @@ func main() {
 	if serverCmd == "" {
 		fmt.Fprintln(flag.CommandLine.Output(), "Missing serverCmd")
 		flag.Usage()
-		os.Exit(2)
+		return 2
 	}
 
-	target, err := url.Parse("http://127.0.0.1:" + *port)
-	if err != nil {
-		log.Fatalf("url parse error: %v", err)
+	view := &slogView{pretty: *prettyLogs, minLevel: math.MinInt, filters: logFilters}
+	if *logLevel != "" {
+		if err := view.minLevel.UnmarshalText([]byte(*logLevel)); err != nil {
+			fmt.Fprintf(flag.CommandLine.Output(), "Invalid -log-level: %v\n", err)
+			return 2
+		}
 	}
 
-	restartCh := make(chan struct{})
-	reload := NewBroadcaster[fsEventBatch]()
+	up := upstream{Network: "tcp", Addr: net.JoinHostPort("127.0.0.1", *port)}
+	if *upstreamAddr != "" {
+		var err error
+		if up, err = parseUpstream(*upstreamAddr); err != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), err)
+			return 2
+		}
+	}
+	if _, err := prepareCommand(serverCmd, up); err != nil {
+		fmt.Fprintln(flag.CommandLine.Output(), err)
+		return 2
+	}
 
-	go rerun(target.Host, restartCh, *buildCmd, serverCmd, reload)
-	go waitForEnter(restartCh)
+	var transport http.RoundTripper = newUpstreamTransport(up, *upstreamH2C)
+	if *staticPrefix != "" {
+		if *webRoot == "" || !strings.HasPrefix(*staticPrefix, "/") {
+			fmt.Fprintln(flag.CommandLine.Output(), "-static-prefix requires -web-root and must start with /")
+			return 2
+		}
+		root, err := os.OpenRoot(*webRoot)
+		if err != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), err)
+			return 1
+		}
+		defer root.Close()
+		transport = newStaticTransport(root.FS(), *staticPrefix, transport)
+	}
 
+	if *httpRedirect != "" && !*useTLS {
+		fmt.Fprintln(flag.CommandLine.Output(), "-http-redirect requires -tls")
+		return 2
+	}
+	scheme := "http"
+	var (
+		tlsConfig *tls.Config
+		caPath    string
+	)
+	if *useTLS {
+		cfg, ca, err := setupTLS(*addr, splitList(*tlsHostNames))
+		if err != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), err)
+			return 1
+		}
+		scheme, tlsConfig, caPath = "https", cfg, ca
+	}
+
+	network := newNetworkSimulator(netConditions{
+		Enabled:   *latency > 0 || *jitter > 0 || *bandwidth > 0 || len(faults) > 0,
+		Latency:   jsonDuration(*latency),
+		Jitter:    jsonDuration(*jitter),
+		Bandwidth: *bandwidth,
+		Faults:    faults,
+	})
+	if err := network.Conditions().Validate(); err != nil {
+		fmt.Fprintf(flag.CommandLine.Output(), "Invalid network conditions: %v\n", err)
+		return 2
+	}
+
+	mocks := newMockServer(*mocksFile)
+	if *mocksFile != "" {
+		n, err := mocks.Load()
+		if err != nil {
+			fmt.Fprintln(flag.CommandLine.Output(), err)
+			return 1
+		}
+		infof("Loaded %d mock routes from %s", n, *mocksFile)
+	}
+
+	ctx, quit := context.WithCancel(context.Background())
+	defer quit()
+
+	// Put the terminal into cbreak mode for single key shortcuts.
+	restoreTerminal, err := cbreak(os.Stdin)
+	keys := err == nil
+	if keys {
+		defer restoreTerminal()
+	} else {
+		restoreTerminal = func() {}
+	}
+
+	// Shut down gracefully on SIGINT and SIGTERM, a second signal exits
+	// right away.
+	var signalExit atomic.Int32
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		code := signalExitCode(<-signals)
+		signalExit.Store(int32(code))
+		quit()
+		<-signals
+		restoreTerminal()
+		fmt.Println("Forced exit")
+		os.Exit(code)
+	}()
+
+	// Keyboard shortcuts pressed while a restart is pending are coalesced
+	// into it, see inputActions.sendRestart.
+	restartCh := make(chan restartRequest, 1)
+	reload := newEventStream(1000)
+	modules := newModuleGraph()
+	status := newServerStatus(reload, *liveReload)
+	logs := newLogMux(os.Stdout, reload, *logTime)
+	if *prettyLogs || *logLevel != "" || len(logFilters) > 0 {
+		logs.format = view.Format
+	}
+	requests := newRequestTracker()
+	scanner := newErrorScanner(errorPatterns, requests, func(e serverError) {
+		reload.Publish(eventServerError, e)
+	})
+	logs.Tap(scanner.Line)
+
+	var rerunErr error
+	rerunDone := make(chan struct{})
+	go func() {
+		rerunErr = rerun(ctx, up, restartCh, *buildCmd, serverCmd, reload, status, logs)
+		if rerunErr != nil {
+			quit()
+		}
+		close(rerunDone)
+	}()
+	go handleInput(os.Stdin, keys, inputActions{
+		restart: restartCh,
+		reload:  func() { reload.Change(fsEventBatch{}) },
+		open:    func() { openBrowser(scheme + "://" + dialAddr(*addr)) },
+		pause: func() bool {
+			paused := !status.Paused()
+			status.SetPaused(paused)
+			return paused
+		},
+		quit: quit,
+	})
+
+	// Go files and the mocks file share a watcher.
+	var (
+		watchExts []string
+		mocksPath string
+	)
 	if *restart {
-		go watchFiles([]string{".go"}, func(b fsEventBatch) {
-			restartCh <- struct{}{}
+		watchExts = append(watchExts, ".go")
+	}
+	if *mocksFile != "" {
+		mocksPath, _ = filepath.Abs(*mocksFile)
+		watchExts = append(watchExts, filepath.Ext(mocksPath))
+	}
+	if len(watchExts) > 0 {
+		go watchFiles(ctx, logs, watchExts, func(b fsEventBatch) {
+			if status.Paused() {
+				return
+			}
+			if mocksPath != "" && slices.ContainsFunc(b, func(e fsEvent) bool {
+				file, _ := filepath.Abs(e.File)
+				return file == mocksPath
+			}) {
+				if n, err := mocks.Load(); err != nil {
+					log.Print(err)
+				} else {
+					infof("Reloaded %d mock routes from %s", n, *mocksFile)
+				}
+			}
+			if !*restart || !slices.ContainsFunc(b, func(e fsEvent) bool { return e.Ext == ".go" }) {
+				return
+			}
+			select {
+			case restartCh <- restartRequest{build: true}:
+			case <-ctx.Done():
+			}
 		})
 	}
-	if *liveReload {
-		go watchFiles([]string{".tmpl", ".html", ".css", ".js"}, func(b fsEventBatch) {
+
+	// The live reload watcher is started when live reload is enabled for
+	// the first time, either by the flag or via the control API.
+	startLiveReload := sync.OnceFunc(func() {
+		exts := liveReloadExts
+		if len(cssMap) > 0 {
+			// Stylesheet sources are only interesting when we know which
+			// stylesheet they are compiled to.
+			exts = append(slices.Clip(exts), ".scss", ".sass", ".less")
+		}
+		css := newCSSResolver(*webRoot, cssMap)
+		go watchFiles(ctx, logs, exts, func(b fsEventBatch) {
+			if !status.LiveReload() || status.Paused() {
+				return
+			}
+
+			css.Update(b)
 			b2 := make(fsEventBatch, len(b))
 			for i := range b {
 				b2[i] = webRootRel(*webRoot, b[i])
+				b2[i].Stylesheets = css.Affected(b[i].File)
+				if b2[i].Ext == ".js" && slices.Contains(b2[i].Events, "Updated") {
+					b2[i].Modules, _ = modules.Update(b2[i].File)
+				}
 			}
-			reload.Broadcast(b2)
+			reload.Change(b2)
 		})
+	})
+	if *liveReload {
+		startLiveReload()
+	}
+
+	control := &controlHandler{
+		restart: restartCh,
+		stream:  reload,
+		status:  status,
+		logs:    logs,
+		network: network,
+		setLiveReload: func(enabled bool) {
+			if enabled {
+				startLiveReload()
+			}
+			status.SetLiveReload(enabled)
+		},
+	}
+
+	if dir, err := os.Getwd(); err == nil {
+		lf := lockfile{PID: os.Getpid(), Addr: *addr, TLS: *useTLS, CA: caPath}
+		if *controlSocket != "" {
+			lf.Socket, _ = filepath.Abs(*controlSocket)
+		}
+		if remove, err := writeLockfile(dir, lf); err == nil {
+			defer remove()
+		} else {
+			log.Print(err)
+		}
+	}
+
+	requestLog := newRequestLog(reload, *requestLogSize, *requestLogBody)
+	if *controlSocket != "" {
+		go func() {
+			if err := serveControlSocket(ctx, *controlSocket, control, &watchHandler{stream: reload}, requestLog); err != nil {
+				log.Printf("control socket: %v", err)
+			}
+		}()
+	}
+
+	proxy := newProxy(proxyOptions{
+		addr:       *addr,
+		target:     up.URL(),
+		stream:     reload,
+		modules:    modules,
+		control:    control,
+		requests:   requests,
+		requestLog: requestLog,
+		mocks:      mocks,
+		network:    network,
+		transport:  transport,
+		client: clientConfig{
+			Morph:   *morph,
+			Toolbar: *toolbar,
+			Overlay: *errorOverlay,
+		},
+	})
+	proxy.TLSConfig = tlsConfig
+	proxy.Protocols = serverProtocols(*h2c)
+	var proxyErr error
+	go func() {
+		var err error
+		if tlsConfig != nil {
+			infof("Serving HTTPS on https://%s", dialAddr(*addr))
+			err = proxy.ListenAndServeTLS("", "")
+		} else {
+			err = proxy.ListenAndServe()
+		}
+		if !errors.Is(err, http.ErrServerClosed) {
+			proxyErr = err
+			quit()
+		}
+	}()
+
+	var redirect *http.Server
+	if *httpRedirect != "" {
+		redirect = &http.Server{
+			Addr:              *httpRedirect,
+			Handler:           redirectToHTTPS(*addr),
+			ReadHeaderTimeout: 1 * time.Minute,
+		}
+		go func() {
+			if err := redirect.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("http redirect: %v", err)
+			}
+		}()
+	}
+
+	<-ctx.Done()
+	infof("Shutting down...")
+	status.SetState(stateStopping, "")
+
+	// Tell the browsers devserver is going away. It also ends the event
+	// streams, Shutdown would wait for them otherwise.
+	reload.Shutdown()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := proxy.Shutdown(shutdownCtx); err != nil {
+		log.Printf("proxy: shutdown: %v", err)
+	}
+	if redirect != nil {
+		redirect.Shutdown(shutdownCtx)
 	}
 
-	runProxy(*addr, target, reload)
+	// rerun stops the server.
+	<-rerunDone
+
+	switch {
+	case proxyErr != nil:
+		fmt.Printf("proxy: %v\n", proxyErr)
+		return 1
+	case rerunErr != nil:
+		fmt.Println(rerunErr)
+		return 1
+	}
+	return int(signalExit.Load())
 }
 
+// setupTLS loads the local CA, creating it on first use, and returns the
+// TLS configuration of the listener on addr and the path of the CA
+// certificate.
+func setupTLS(addr string, hosts []string) (*tls.Config, string, error) {
+	dir, err := certDir()
+	if err != nil {
+		return nil, "", fmt.Errorf("tls: %w", err)
+	}
+	ca, created, err := loadCA(dir)
+	if err != nil {
+		return nil, "", err
+	}
+	if created {
+		infof("%s", trustInstructions(ca.CertPath()))
+	}
+	cert, err := ca.Certificate(tlsHosts(addr, hosts))
+	if err != nil {
+		return nil, "", err
+	}
+	return &tls.Config{Certificates: []tls.Certificate{cert}}, ca.CertPath(), nil
+}
+
+// shutdownTimeout is how long devserver waits for open connections to finish
+// when shutting down.
+const shutdownTimeout = 5 * time.Second
+
+// signalExitCode returns the conventional exit code of a process terminated
+// by sig.
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return 1
+}
+
+// assetExts are the extensions of images, fonts and other static assets.
+// reload.js swaps changed assets in place, pages not using them are left
+// alone.
+var assetExts = []string{
+	// images
+	".svg", ".png", ".jpg", ".jpeg", ".gif", ".webp", ".avif", ".ico",
+	// fonts
+	".woff", ".woff2", ".ttf", ".otf",
+}
+
+// liveReloadExts are the extensions of the files that trigger a live reload
+// or an in place update in the browser.
+var liveReloadExts = slices.Concat([]string{".tmpl", ".html", ".css", ".js"}, assetExts)
+
 func webRootRel(webRoot string, e fsEvent) fsEvent {
 	if webRoot == "" {
 		return e
@@ -91,49 +476,68 @@ func webRootRel(webRoot string, e fsEvent) fsEvent {
 	return e
 }
 
-// waitForEnter waits for a new line on os.Stdin. When a new line is received
-// it sends a message on the ch channel.
-func waitForEnter(ch chan<- struct{}) {
-	fmt.Println("Hit Enter to rebuild and restart")
-
-	s := bufio.NewScanner(os.Stdin)
-	for s.Scan() {
-		ch <- struct{}{}
-	}
+// restartRequest asks rerun to restart the server.
+type restartRequest struct {
+	// build the server before restarting
+	build bool
+	// done receives the status after the restart if not nil. It must be
+	// buffered.
+	done chan<- statusSnapshot
 }
 
 // rerun builds and runs the server over and over again. A message on the
-// restart channel initiates rebuild & restart.
+// restart channel initiates (re)build & restart. When ctx is cancelled a
+// build in progress is cancelled, the server is stopped and rerun returns. An
+// error is returned if the first build fails.
 func rerun(
-	addr string,
-	restart <-chan struct{},
+	ctx context.Context,
+	up upstream,
+	restart <-chan restartRequest,
 	buildCmd string,
 	serverCmd string,
-	reload *Broadcaster[fsEventBatch],
-) {
+	reload *eventStream,
+	status *serverStatus,
+	logs *logMux,
+) error {
 
 	// build -> stop -> run
-	run := func(stop func()) (func(), bool) {
-		ctx, cancel := context.WithCancel(context.Background())
-
-		if !build(ctx, buildCmd) {
-			fmt.Println("build failed")
-			if stop == nil {
-				// Exit immediately if this is the first build
-				os.Exit(1)
+	run := func(stop func(), doBuild bool) (func(), bool) {
+		// Cancelling ctx stops this build and server.
+		ctx, cancel := context.WithCancel(ctx)
+
+		if doBuild {
+			status.SetState(stateBuilding, "")
+			start := time.Now()
+			ok := build(ctx, buildCmd, logs)
+			status.BuildDone(time.Since(start), ok)
+
+			if !ok {
+				logs.Print(sourceBuild, streamStderr, "build failed")
+				if stop == nil {
+					// Exit immediately if this is the first build
+					cancel()
+					return nil, false
+				}
+
+				status.SetState(stateError, "build failed")
+				cancel()
+				// Return the stop function so the next call to rerun can stop the
+				// server.
+				return stop, false
 			}
-
-			cancel()
-			// Return the stop function so the next call to rerun can stop the
-			// server.
-			return stop, false
 		}
 
+		status.SetState(stateRestarting, "")
 		if stop != nil {
 			stop()
 		}
 
-		done := startServer(ctx, addr, serverCmd)
+		pid, done := startServer(ctx, up, serverCmd, logs)
+		status.ServerStarted(pid)
+		go func() {
+			<-done
+			status.ServerStopped(pid)
+		}()
 
 		return func() {
 			cancel() // Stop the server
@@ -141,28 +545,66 @@ func rerun(
 			select {
 			case <-done: // Wait for server to stop
 				infof("Stopped server")
-			case <-time.After(10 * time.Second):
-				log.Print("server stop timeout after 10 seconds")
+			case <-time.After(stopTimeout):
+				log.Printf("server stop timeout after %s, killing it", stopTimeout)
+				killProcessGroup(pid)
+				<-done
 			}
 		}, true
 	}
 
-	stop, restarted := run(nil)
-	for range restart {
+	stop, restarted := run(nil, true)
+	if !restarted {
+		if ctx.Err() != nil {
+			// The build was interrupted.
+			return nil
+		}
+		return errors.New("first build failed, exiting")
+	}
+	if err := connectWithRetry(ctx, up); err == nil {
+		status.SetState(stateIdle, "")
+	} else {
+		status.SetState(stateError, err.Error())
+	}
+
+	for {
+		var req restartRequest
+		select {
+		case <-ctx.Done():
+			// Stop the server before exiting
+			stop()
+			return nil
+		case req = <-restart:
+		}
+		if ctx.Err() != nil {
+			stop()
+			if req.done != nil {
+				req.done <- status.Snapshot()
+			}
+			return nil
+		}
+
 		infof("Restarting...")
-		stop, restarted = run(stop)
+		stop, restarted = run(stop, req.build)
+
+		if restarted {
+			if err := connectWithRetry(ctx, up); err == nil {
+				status.SetState(stateIdle, "")
+				reload.Change(fsEventBatch{})
+			} else {
+				status.SetState(stateError, err.Error())
+			}
+		}
 
-		if err := connectWithRetry(context.Background(), addr); restarted && err == nil {
-			reload.Broadcast(fsEventBatch{})
+		if req.done != nil {
+			req.done <- status.Snapshot()
 		}
 	}
-
-	// Stop the server before exiting
-	stop()
 }
 
-// Build the server binary using buildCmd.
-func build(ctx context.Context, buildCmd string) bool {
+// Build the server binary using buildCmd. The output of the build is written
+// to logs. It returns whether the build succeeded.
+func build(ctx context.Context, buildCmd string, logs *logMux) bool {
 	if buildCmd == "" {
 		return true
 	}
@@ -174,69 +616,107 @@ func build(ctx context.Context, buildCmd string) bool {
 
 	start := time.Now()
 	infof("Building...")
-	fmt.Println(time.Now().Format(time.UnixDate))
+	logs.Print(sourceBuild, streamStdout, time.Now().Format(time.UnixDate))
 	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
-
-	out, err := cmd.CombinedOutput()
+	stdout, stderr := logs.Writer(sourceBuild, streamStdout), logs.Writer(sourceBuild, streamStderr)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
+	cmd.WaitDelay = stopTimeout
+
+	err = cmd.Run()
+	stdout.Close()
+	stderr.Close()
 	if err != nil {
-		fmt.Printf("build error: %s\n", err)
+		logs.Print(sourceBuild, streamStderr, fmt.Sprintf("build error: %s", err))
 	}
-	fmt.Printf("%s", out)
 
 	infof("Build done; took %s", time.Since(start))
 
-	return cmd.ProcessState.Success()
+	return err == nil
 }
 
 // Start the server using serverCmd. In serverCmd placeholders are replaced. See below.
-// When ctx is cancelled SIGTERM is sent to the server process.
+// The server runs in its own process group. When ctx is cancelled SIGTERM is
+// sent to the process group.
 //
 // The following placeholders are recognized:
 //
 // {} is replaced by host:port
 // {host} is replaced by host
 // {port} is replaced by port
-func startServer(ctx context.Context, addr string, serverCmd string) <-chan struct{} {
-	args, err := prepareCommand(serverCmd, addr)
+// {socket} is replaced by the path of the socket of a unix upstream
+//
+// The output of the server is written to logs. It returns the PID of the
+// server process, or 0 if the server could not be started, and a channel that
+// is closed when the server exits.
+func startServer(ctx context.Context, up upstream, serverCmd string, logs *logMux) (int, <-chan struct{}) {
+	args, err := prepareCommand(serverCmd, up)
 	if err != nil {
 		log.Fatal(err)
 	}
+	if err := up.removeStaleSocket(); err != nil {
+		logs.Print(sourceServer, streamStderr, fmt.Sprintf("server error: %s", err))
+	}
 
 	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Cancel = func() error {
-		infof("Sending SIGTERM to pid %d", cmd.Process.Pid)
-		return cmd.Process.Signal(syscall.SIGTERM)
-	}
+	stdout, stderr := logs.Writer(sourceServer, streamStdout), logs.Writer(sourceServer, streamStderr)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	setProcessGroup(cmd)
 
 	done := make(chan struct{})
 
+	if err := cmd.Start(); err != nil {
+		logs.Print(sourceServer, streamStderr, fmt.Sprintf("server error: %s", err))
+		close(done)
+		return 0, done
+	}
+
 	go func() {
-		if err := cmd.Run(); err != nil && !errors.Is(err, context.Canceled) {
-			fmt.Printf("server error: %s\n", err)
+		err := cmd.Wait()
+		stdout.Close()
+		stderr.Close()
+		if err != nil && ctx.Err() == nil {
+			logs.Print(sourceServer, streamStderr, fmt.Sprintf("server error: %s", err))
 		}
 		close(done)
 	}()
 
 	infof("Started server: %v", cmd)
-	return done
+	return cmd.Process.Pid, done
 }
 
-func prepareCommand(serverCmd string, addr string) ([]string, error) {
+// stopTimeout is how long a build or the server gets to exit after SIGTERM
+// before it is killed.
+const stopTimeout = 10 * time.Second
+
+func prepareCommand(serverCmd string, up upstream) ([]string, error) {
 	args, err := shlex.Split(serverCmd)
 	if err != nil {
 		return nil, fmt.Errorf("server command parser error: %w", err)
 	}
 
-	host, port, err := net.SplitHostPort(addr)
+	if up.Network == "unix" {
+		for i, arg := range args {
+			switch arg {
+			case "{socket}":
+				args[i] = up.Addr
+			case "{}", "{host}", "{port}":
+				return nil, fmt.Errorf("placeholder %s requires a host:port upstream, use {socket}", arg)
+			}
+		}
+		return args, nil
+	}
+
+	host, port, err := net.SplitHostPort(up.Addr)
 	if err != nil {
 		return nil, fmt.Errorf("addr is not host:port: %w", err)
 	}
 
 	for i, arg := range args {
 		if arg == "{}" {
-			args[i] = addr
+			args[i] = up.Addr
 		}
 		if arg == "{port}" {
 			args[i] = port
@@ -244,18 +724,21 @@ func prepareCommand(serverCmd string, addr string) ([]string, error) {
 		if arg == "{host}" {
 			args[i] = host
 		}
+		if arg == "{socket}" {
+			return nil, fmt.Errorf("placeholder {socket} requires a unix:// upstream")
+		}
 	}
 	return args, nil
 }
 
-func connectWithRetry(ctx context.Context, addr string) error {
+func connectWithRetry(ctx context.Context, up upstream) error {
 	const (
 		initialDelay = 500 * time.Millisecond
 		maxRetries   = 10
 	)
 
 	tryConnect := func() error {
-		c, err := net.Dial("tcp", addr)
+		c, err := up.Dial(ctx)
 		if err == nil {
 			c.Close()
 		}
@@ -1,27 +1,65 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/exec"
 	"reflect"
 	"testing"
+	"time"
 )
 
+func TestRun_FirstBuildFails(t *testing.T) {
+	// run parses the command line flags, so it runs in a child process.
+	if os.Getenv("DEVSERVER_TEST_RUN") == "1" {
+		os.Args = []string{"devserver", "-addr", "127.0.0.1:0", "-live-reload=false", "-restart=false", "-build-cmd", "false", "sleep 100"}
+		os.Exit(run())
+	}
+	if _, err := exec.LookPath("false"); err != nil {
+		t.Skip("false is not available")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.CommandContext(ctx, exe, "-test.run=^TestRun_FirstBuildFails$")
+	cmd.Dir = t.TempDir()
+	cmd.Env = append(os.Environ(), "DEVSERVER_TEST_RUN=1")
+	out, _ := cmd.CombinedOutput()
+
+	if ctx.Err() != nil {
+		t.Fatalf("devserver did not exit after the first build failed\n%s", out)
+	}
+	if code := cmd.ProcessState.ExitCode(); code != 1 {
+		t.Errorf("unexpected exit code\nwant: 1\ngot:  %d\n%s", code, out)
+	}
+}
+
 func TestPrepareCommand(t *testing.T) {
+	tcp := func(addr string) upstream { return upstream{Network: "tcp", Addr: addr} }
+	socket := upstream{Network: "unix", Addr: "/tmp/app.sock"}
 	testCases := []struct {
 		serverCmd string
-		addr      string
+		up        upstream
 		args      []string
 		wantError bool
 	}{
-		{"bin/server -addr {}", "localhost:8888", []string{"bin/server", "-addr", "localhost:8888"}, false},
-		{"bin/server -port {port}", "localhost:8888", []string{"bin/server", "-port", "8888"}, false},
-		{"bin/server -host {host}", "localhost:8888", []string{"bin/server", "-host", "localhost"}, false},
-		{"bin/server -host {host} -port {port}", "localhost:8888", []string{"bin/server", "-host", "localhost", "-port", "8888"}, false},
-		{"bin/server -host {host} -port {port}", "localhost", nil, true},
+		{"bin/server -addr {}", tcp("localhost:8888"), []string{"bin/server", "-addr", "localhost:8888"}, false},
+		{"bin/server -port {port}", tcp("localhost:8888"), []string{"bin/server", "-port", "8888"}, false},
+		{"bin/server -host {host}", tcp("localhost:8888"), []string{"bin/server", "-host", "localhost"}, false},
+		{"bin/server -host {host} -port {port}", tcp("localhost:8888"), []string{"bin/server", "-host", "localhost", "-port", "8888"}, false},
+		{"bin/server -host {host} -port {port}", tcp("localhost"), nil, true},
+		{"bin/server -socket {socket}", tcp("localhost:8888"), nil, true},
+		{"bin/server -socket {socket}", socket, []string{"bin/server", "-socket", "/tmp/app.sock"}, false},
+		{"bin/server -port {port}", socket, nil, true},
 	}
 
 	for _, tt := range testCases {
 		t.Run(tt.serverCmd, func(t *testing.T) {
-			result, err := prepareCommand(tt.serverCmd, tt.addr)
+			result, err := prepareCommand(tt.serverCmd, tt.up)
 			checkError(t, err, tt.wantError)
 
 			if !reflect.DeepEqual(result, tt.args) {
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync/atomic"
+	"text/template"
+)
+
+// mockHeader is set on stubbed responses. Its value is the pattern of the
+// route.
+const mockHeader = "X-Devserver-Mock"
+
+// mockConfig is the file passed to -mocks.
+//
+//	{
+//	  "routes": [
+//	    {"method": "GET", "path": "/api/users/{id}", "template": true,
+//	     "headers": {"Content-Type": "application/json"},
+//	     "body": "{\"id\": \"{{.Params.id}}\"}"},
+//	    {"path": "/api/report", "file": "mocks/report.json"}
+//	  ]
+//	}
+type mockConfig struct {
+	Routes []mockRoute `json:"routes"`
+}
+
+// mockRoute is a stubbed endpoint. Path is a net/http.ServeMux pattern
+// without the method, e.g. /api/users/{id} or /static/.
+type mockRoute struct {
+	Method  string            `json:"method"` // any method if empty
+	Path    string            `json:"path"`
+	Status  int               `json:"status"` // 200 if zero
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+	// File is read on every request, relative to the config file. It
+	// takes precedence over Body.
+	File string `json:"file"`
+	// Template executes the body as a text/template with mockData.
+	Template bool `json:"template"`
+}
+
+// mockData is passed to body templates.
+type mockData struct {
+	Method string
+	Path   string
+	Params map[string]string // path wildcards
+	Query  url.Values
+}
+
+// mockServer serves the stub routes of a mockConfig file. The file can be
+// reloaded while serving. Without a file no route is stubbed.
+type mockServer struct {
+	path string
+	mux  atomic.Pointer[http.ServeMux]
+}
+
+func newMockServer(path string) *mockServer {
+	m := &mockServer{path: path}
+	m.mux.Store(http.NewServeMux())
+	return m
+}
+
+// Load reads the config file and replaces the routes. The routes are kept if
+// the file is invalid. It returns the number of routes.
+func (m *mockServer) Load() (int, error) {
+	b, err := os.ReadFile(m.path)
+	if err != nil {
+		return 0, fmt.Errorf("mocks: %w", err)
+	}
+	var cfg mockConfig
+	if err := json.Unmarshal(b, &cfg); err != nil {
+		return 0, fmt.Errorf("mocks: %s: %w", m.path, err)
+	}
+
+	mux := http.NewServeMux()
+	dir := filepath.Dir(m.path)
+	for i, route := range cfg.Routes {
+		h, err := newMockHandler(route, dir)
+		if err != nil {
+			return 0, fmt.Errorf("mocks: %s: route %d: %w", m.path, i+1, err)
+		}
+		if err := handlePattern(mux, route.pattern(), h); err != nil {
+			return 0, fmt.Errorf("mocks: %s: route %d: %w", m.path, i+1, err)
+		}
+	}
+	m.mux.Store(mux)
+	return len(cfg.Routes), nil
+}
+
+// Wrap serves the stub routes and passes every other request to next.
+func (m *mockServer) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mux := m.mux.Load()
+		if _, pattern := mux.Handler(r); pattern == "" || isReplay(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
+
+func (r mockRoute) pattern() string {
+	if r.Method == "" {
+		return r.Path
+	}
+	return r.Method + " " + r.Path
+}
+
+// handlePattern registers h for pattern. Invalid and conflicting patterns
+// are returned as errors instead of panicking.
+func handlePattern(mux *http.ServeMux, pattern string, h http.Handler) (err error) {
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("%v", v)
+		}
+	}()
+	mux.Handle(pattern, h)
+	return nil
+}
+
+// wildcardRe matches the wildcards of a ServeMux pattern, e.g. {id} or
+// {path...}.
+var wildcardRe = regexp.MustCompile(`\{([^}.]+)(?:\.\.\.)?\}`)
+
+func newMockHandler(route mockRoute, dir string) (http.Handler, error) {
+	if !strings.HasPrefix(route.Path, "/") {
+		return nil, fmt.Errorf("invalid path %q, must start with /", route.Path)
+	}
+
+	var params []string
+	for _, m := range wildcardRe.FindAllStringSubmatch(route.Path, -1) {
+		if m[1] != "$" {
+			params = append(params, m[1])
+		}
+	}
+
+	file := route.File
+	if file != "" && !filepath.IsAbs(file) {
+		file = filepath.Join(dir, file)
+	}
+	var tmpl *template.Template
+	if route.Template && file == "" {
+		t, err := template.New(route.Path).Parse(route.Body)
+		if err != nil {
+			return nil, err
+		}
+		tmpl = t
+	}
+
+	status := route.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := []byte(route.Body)
+		if file != "" {
+			b, err := os.ReadFile(file)
+			if err != nil {
+				http.Error(w, fmt.Sprintf("mock %s: %v", route.pattern(), err), http.StatusInternalServerError)
+				return
+			}
+			body = b
+		}
+
+		if route.Template {
+			t := tmpl
+			if t == nil {
+				var err error
+				if t, err = template.New(file).Parse(string(body)); err != nil {
+					http.Error(w, fmt.Sprintf("mock %s: %v", route.pattern(), err), http.StatusInternalServerError)
+					return
+				}
+			}
+			data := mockData{
+				Method: r.Method,
+				Path:   r.URL.Path,
+				Params: map[string]string{},
+				Query:  r.URL.Query(),
+			}
+			for _, p := range params {
+				data.Params[p] = r.PathValue(p)
+			}
+			var buf bytes.Buffer
+			if err := t.Execute(&buf, data); err != nil {
+				http.Error(w, fmt.Sprintf("mock %s: %v", route.pattern(), err), http.StatusInternalServerError)
+				return
+			}
+			body = buf.Bytes()
+		}
+
+		for name, value := range route.Headers {
+			w.Header().Set(name, value)
+		}
+		if w.Header().Get("content-type") == "" && file != "" {
+			if ct := mime.TypeByExtension(filepath.Ext(file)); ct != "" {
+				w.Header().Set("content-type", ct)
+			}
+		}
+		w.Header().Set(mockHeader, route.pattern())
+		w.WriteHeader(status)
+		w.Write(body)
+	}), nil
+}
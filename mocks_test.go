@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMockServer(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "mocks.json")
+	write := func(name, content string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("report.json", `{"total": 3}`)
+	write("user.txt", `user {{.Params.id}} {{.Query.Get "q"}}`)
+	write("mocks.json", `{"routes": [
+		{"method": "GET", "path": "/api/users/{id}", "template": true, "body": "{\"id\": \"{{.Params.id}}\"}",
+		 "headers": {"Content-Type": "application/json"}},
+		{"method": "POST", "path": "/api/users", "status": 201},
+		{"path": "/api/report", "file": "report.json"},
+		{"path": "/api/files/{id}", "file": "user.txt", "template": true}
+	]}`)
+
+	m := newMockServer(config)
+	if n, err := m.Load(); err != nil || n != 4 {
+		t.Fatalf("Load() = %d, %v", n, err)
+	}
+	h := m.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("upstream"))
+	}))
+
+	tests := []struct {
+		method, path string
+		status       int
+		contentType  string
+		body         string
+	}{
+		{"GET", "/api/users/42", 200, "application/json", `{"id": "42"}`},
+		{"POST", "/api/users", 201, "", ""},
+		{"GET", "/api/users", 200, "", "upstream"},
+		{"GET", "/api/report", 200, "application/json", `{"total": 3}`},
+		{"GET", "/api/files/7?q=x", 200, "text/plain; charset=utf-8", "user 7 x"},
+		{"GET", "/other", 200, "", "upstream"},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.status || rec.Body.String() != tt.body {
+			t.Errorf("%s %s\nwant: %d %q\ngot:  %d %q", tt.method, tt.path, tt.status, tt.body, rec.Code, rec.Body.String())
+		}
+		if tt.contentType != "" && rec.Header().Get("content-type") != tt.contentType {
+			t.Errorf("%s %s: unexpected content type %q", tt.method, tt.path, rec.Header().Get("content-type"))
+		}
+	}
+
+	// Invalid configs keep the current routes.
+	for _, content := range []string{
+		`{"routes": [{"path": "api"}]}`,
+		`{"routes": [{"path": "/a/{x}"}, {"path": "/a/{y}"}]}`,
+		`{"routes": [{"path": "/a", "template": true, "body": "{{"}]}`,
+		`{"routes": `,
+	} {
+		write("mocks.json", content)
+		if _, err := m.Load(); err == nil {
+			t.Errorf("expected an error for %s", content)
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/report", nil))
+	if rec.Header().Get(mockHeader) != "/api/report" {
+		t.Errorf("expected the routes to be kept, got %q", rec.Body.String())
+	}
+
+	write("mocks.json", `{"routes": []}`)
+	if _, err := m.Load(); err != nil {
+		t.Fatal(err)
+	}
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/api/report", nil))
+	if rec.Body.String() != "upstream" {
+		t.Errorf("expected the request to be proxied after reload, got %q", rec.Body.String())
+	}
+}
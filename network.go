@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// faultHeader is set on responses of injected errors. Its value is the kind
+// of the fault.
+const faultHeader = "X-Devserver-Fault"
+
+// timeoutFaultDelay is how long a request hit by a timeout fault is held
+// before it is answered with 504 Gateway Timeout.
+const timeoutFaultDelay = 30 * time.Second
+
+// Kinds of faults besides HTTP status codes.
+const (
+	faultReset   = "reset"   // close the connection without a response
+	faultTimeout = "timeout" // do not respond, see timeoutFaultDelay
+)
+
+// netConditions are the simulated network conditions of proxied requests.
+type netConditions struct {
+	Enabled bool `json:"enabled"`
+	// Latency delays every request, Jitter adds a random delay of up to
+	// Jitter.
+	Latency jsonDuration `json:"latency"`
+	Jitter  jsonDuration `json:"jitter"`
+	// Bandwidth throttles response bodies to this many KiB per second,
+	// 0 is unlimited.
+	Bandwidth int        `json:"bandwidth"`
+	Faults    []netFault `json:"faults"`
+}
+
+// netFault fails the requests matching Path at Rate.
+type netFault struct {
+	// Path is a glob matched against the URL path, see matchGlob. Every
+	// path matches if empty.
+	Path string `json:"path,omitempty"`
+	// Kind is an HTTP status code, reset or timeout.
+	Kind string  `json:"kind"`
+	Rate float64 `json:"rate"` // 0 to 1
+}
+
+// jsonDuration is a time.Duration encoded as a string, e.g. "200ms".
+type jsonDuration time.Duration
+
+func (d jsonDuration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+func (d *jsonDuration) UnmarshalText(b []byte) error {
+	v, err := time.ParseDuration(string(b))
+	*d = jsonDuration(v)
+	return err
+}
+
+// Validate reports invalid conditions.
+func (c netConditions) Validate() error {
+	if c.Latency < 0 || c.Jitter < 0 || c.Bandwidth < 0 {
+		return errors.New("latency, jitter and bandwidth must not be negative")
+	}
+	for _, f := range c.Faults {
+		if err := f.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (f netFault) validate() error {
+	if f.Rate < 0 || f.Rate > 1 {
+		return fmt.Errorf("fault %s: rate must be between 0 and 1", f.Kind)
+	}
+	if _, err := path.Match(f.Path, ""); err != nil {
+		return fmt.Errorf("fault %s: invalid path %q: %w", f.Kind, f.Path, err)
+	}
+	if f.Kind == faultReset || f.Kind == faultTimeout {
+		return nil
+	}
+	if code, err := strconv.Atoi(f.Kind); err != nil || code < 400 || code > 599 {
+		return fmt.Errorf("invalid fault %q, expected a 4xx or 5xx status code, reset or timeout", f.Kind)
+	}
+	return nil
+}
+
+func (f netFault) String() string {
+	s := fmt.Sprintf("%s@%g", f.Kind, f.Rate)
+	if f.Path != "" {
+		s = f.Path + "=" + s
+	}
+	return s
+}
+
+// faultFlag collects the values of the repeatable -fault flag.
+type faultFlag []netFault
+
+func (f *faultFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	var s []string
+	for _, fault := range *f {
+		s = append(s, fault.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+// Set parses [path=]kind@rate, e.g. /api/**=503@0.1. The rate can be given
+// in percent too, e.g. 10%.
+func (f *faultFlag) Set(v string) error {
+	var fault netFault
+	spec := v
+	if p, rest, ok := strings.Cut(v, "="); ok {
+		fault.Path, spec = p, rest
+	}
+	kind, rate, ok := strings.Cut(spec, "@")
+	if !ok {
+		return fmt.Errorf("invalid fault %q, expected [path=]kind@rate", v)
+	}
+	fault.Kind = kind
+
+	var err error
+	if p, ok := strings.CutSuffix(rate, "%"); ok {
+		fault.Rate, err = strconv.ParseFloat(p, 64)
+		fault.Rate /= 100
+	} else {
+		fault.Rate, err = strconv.ParseFloat(rate, 64)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid fault rate %q", rate)
+	}
+	if err := fault.validate(); err != nil {
+		return err
+	}
+	*f = append(*f, fault)
+	return nil
+}
+
+// networkSimulator applies netConditions to proxied requests. The conditions
+// can be changed at runtime.
+type networkSimulator struct {
+	mu   sync.Mutex
+	cond netConditions
+}
+
+func newNetworkSimulator(cond netConditions) *networkSimulator {
+	return &networkSimulator{cond: cond}
+}
+
+// Conditions returns the current conditions.
+func (n *networkSimulator) Conditions() netConditions {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.cond
+}
+
+// Set replaces the conditions.
+func (n *networkSimulator) Set(cond netConditions) error {
+	if err := cond.Validate(); err != nil {
+		return err
+	}
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.cond = cond
+	return nil
+}
+
+// Wrap applies the current conditions to the requests handled by next.
+func (n *networkSimulator) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := n.Conditions()
+		if !c.Enabled || isReplay(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		delay := time.Duration(c.Latency)
+		if c.Jitter > 0 {
+			delay += rand.N(time.Duration(c.Jitter) + 1)
+		}
+		if !sleep(r.Context(), delay) {
+			return
+		}
+
+		for _, f := range c.Faults {
+			if (f.Path == "" || matchGlob(f.Path, r.URL.Path)) && rand.Float64() < f.Rate {
+				injectFault(w, r, f.Kind)
+				return
+			}
+		}
+
+		if c.Bandwidth > 0 {
+			w = &throttledWriter{
+				ResponseWriter: w,
+				ctx:            r.Context(),
+				rate:           int64(c.Bandwidth) * 1024,
+				start:          time.Now(),
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func injectFault(w http.ResponseWriter, r *http.Request, kind string) {
+	// Tells the request log about the fault, the header is not sent for
+	// resets.
+	w.Header().Set(faultHeader, kind)
+	switch kind {
+	case faultReset:
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			// HTTP/2 streams are reset by aborting the handler.
+			panic(http.ErrAbortHandler)
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			// Discard unsent data and send RST instead of FIN.
+			tcp.SetLinger(0)
+		}
+		conn.Close()
+	case faultTimeout:
+		if sleep(r.Context(), timeoutFaultDelay) {
+			http.Error(w, "devserver: simulated timeout", http.StatusGatewayTimeout)
+		}
+	default:
+		code, _ := strconv.Atoi(kind)
+		http.Error(w, fmt.Sprintf("devserver: simulated %d %s", code, http.StatusText(code)), code)
+	}
+}
+
+// sleep waits for d. It returns false if ctx is done first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// throttledWriter writes the response body at rate bytes per second. The
+// body is written and flushed in chunks of a tenth of the rate.
+type throttledWriter struct {
+	http.ResponseWriter
+	ctx     context.Context
+	rate    int64
+	start   time.Time
+	written int64
+}
+
+func (w *throttledWriter) Write(p []byte) (int, error) {
+	chunk := max(w.rate/10, 1)
+	var n int
+	for len(p) > 0 {
+		m, err := w.ResponseWriter.Write(p[:min(int64(len(p)), chunk)])
+		n += m
+		w.written += int64(m)
+		if err != nil {
+			return n, err
+		}
+		p = p[m:]
+		http.NewResponseController(w.ResponseWriter).Flush()
+
+		due := w.start.Add(time.Duration(w.written * int64(time.Second) / w.rate))
+		if !sleep(w.ctx, time.Until(due)) {
+			return n, w.ctx.Err()
+		}
+	}
+	return n, nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (w *throttledWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
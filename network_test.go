@@ -0,0 +1,101 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFaultFlag(t *testing.T) {
+	tests := []struct {
+		value string
+		want  netFault
+		err   bool
+	}{
+		{"503@0.1", netFault{Kind: "503", Rate: 0.1}, false},
+		{"/api/**=reset@25%", netFault{Path: "/api/**", Kind: "reset", Rate: 0.25}, false},
+		{"/slow=timeout@1", netFault{Path: "/slow", Kind: "timeout", Rate: 1}, false},
+		{"503", netFault{}, true},
+		{"200@0.5", netFault{}, true},
+		{"drop@0.5", netFault{}, true},
+		{"503@2", netFault{}, true},
+		{"503@x", netFault{}, true},
+		{"[=503@1", netFault{}, true},
+	}
+
+	for _, tt := range tests {
+		var f faultFlag
+		err := f.Set(tt.value)
+		if tt.err {
+			if err == nil {
+				t.Errorf("Set(%q): expected an error but got none", tt.value)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Set(%q): unexpected error: %v", tt.value, err)
+			continue
+		}
+		if !reflect.DeepEqual(f, faultFlag{tt.want}) {
+			t.Errorf("Set(%q)\nwant: %+v\ngot:  %+v", tt.value, tt.want, f)
+		}
+	}
+}
+
+func TestNetworkSimulator(t *testing.T) {
+	body := strings.Repeat("x", 2048)
+	n := newNetworkSimulator(netConditions{})
+	h := n.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	get := func(path string) (*httptest.ResponseRecorder, time.Duration) {
+		start := time.Now()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec, time.Since(start)
+	}
+
+	n.Set(netConditions{Latency: jsonDuration(time.Hour), Faults: []netFault{{Kind: "503", Rate: 1}}})
+	if rec, _ := get("/"); rec.Code != http.StatusOK || rec.Body.String() != body {
+		t.Errorf("expected disabled conditions to be ignored, got %d", rec.Code)
+	}
+
+	n.Set(netConditions{Enabled: true, Latency: jsonDuration(50 * time.Millisecond)})
+	if _, d := get("/"); d < 50*time.Millisecond {
+		t.Errorf("expected a delay of at least 50ms, got %s", d)
+	}
+
+	n.Set(netConditions{Enabled: true, Bandwidth: 10})
+	if rec, d := get("/"); d < 150*time.Millisecond || rec.Body.String() != body {
+		t.Errorf("expected 2 KiB to take about 200ms at 10 KiB/s, got %s", d)
+	}
+
+	n.Set(netConditions{Enabled: true, Faults: []netFault{
+		{Path: "/api/**", Kind: "503", Rate: 1},
+		{Path: "/never", Kind: "500", Rate: 0},
+	}})
+	if rec, _ := get("/api/users/1"); rec.Code != http.StatusServiceUnavailable || rec.Header().Get(faultHeader) != "503" {
+		t.Errorf("expected an injected 503, got %d", rec.Code)
+	}
+	for _, path := range []string{"/other", "/never"} {
+		if rec, _ := get(path); rec.Code != http.StatusOK {
+			t.Errorf("%s: expected no fault, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestNetworkSimulator_Reset(t *testing.T) {
+	n := newNetworkSimulator(netConditions{Enabled: true, Faults: []netFault{{Kind: faultReset, Rate: 1}}})
+	srv := httptest.NewServer(n.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatalf("expected the connection to be reset, got %s", resp.Status)
+	}
+}
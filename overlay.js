@@ -0,0 +1,95 @@
+// overlay.js shows server panics and errors in an overlay. It is served at
+// /_dev/overlay.js and loaded by reload.js when the overlay is enabled.
+
+const maxErrors = 10;
+
+const css = `
+:host { all: initial; }
+.overlay {
+	position: fixed; inset: 0 0 auto 0; z-index: 2147483647;
+	max-height: 60vh; overflow: auto;
+	background: rgba(33, 33, 33, .95); color: #fff;
+	font: 13px/1.4 system-ui, sans-serif;
+	box-shadow: 0 2px 8px rgba(0, 0, 0, .3);
+	border-top: 4px solid #c62828;
+}
+header { display: flex; align-items: center; gap: 8px; padding: 8px 12px; }
+h1 { flex: 1; margin: 0; font-size: 14px; }
+.error { padding: 8px 12px; border-top: 1px solid rgba(255, 255, 255, .15); }
+.message { color: #ef9a9a; font-weight: bold; }
+.request, .time { color: #bdbdbd; }
+pre { margin: 8px 0 0; white-space: pre-wrap; font: 12px/1.4 ui-monospace, monospace; }
+button {
+	font: inherit; color: inherit; cursor: pointer;
+	background: rgba(255, 255, 255, .15); border: 0; border-radius: 8px; padding: 2px 8px;
+}
+button:hover { background: rgba(255, 255, 255, .3); }
+`;
+
+let root = null;
+let host = null;
+const errors = [];
+
+const mount = () => {
+	host = document.createElement("div");
+	host.setAttribute("data-devserver", "");
+	root = host.attachShadow({ mode: "closed" });
+	root.innerHTML = `
+		<style>${css}</style>
+		<div class="overlay" part="overlay">
+			<header>
+				<h1></h1>
+				<button data-action="close">Close</button>
+			</header>
+			<div class="errors"></div>
+		</div>`;
+	root.querySelector("[data-action=close]").addEventListener("click", () => {
+		errors.length = 0;
+		host.remove();
+	});
+};
+
+const render = () => {
+	root.querySelector("h1").textContent = errors.length === 1 ? "Server error" : `${errors.length} server errors`;
+
+	const list = root.querySelector(".errors");
+	list.replaceChildren();
+	for (const err of errors) {
+		const el = document.createElement("div");
+		el.className = "error";
+
+		const message = document.createElement("div");
+		message.className = "message";
+		message.textContent = err.message;
+		el.append(message);
+
+		const meta = document.createElement("div");
+		meta.className = "request";
+		meta.textContent = [
+			err.request ? `${err.request.method} ${err.request.url}` : "",
+			new Date(err.time).toLocaleTimeString(),
+		].filter(Boolean).join(" · ");
+		el.append(meta);
+
+		if (err.text !== err.message) {
+			const pre = document.createElement("pre");
+			pre.textContent = err.text;
+			el.append(pre);
+		}
+		list.append(el);
+	}
+};
+
+// show adds err, a serverError, to the overlay. The newest error is shown
+// first.
+export const show = (err) => {
+	if (root === null) {
+		mount();
+	}
+	errors.unshift(err);
+	errors.length = Math.min(errors.length, maxErrors);
+	render();
+	if (!host.isConnected) {
+		document.body.appendChild(host);
+	}
+};
@@ -0,0 +1,38 @@
+//go:build !unix
+
+package main
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup makes cancelling cmd's context kill the process. There
+// are no process groups and no SIGTERM on this platform, processes started
+// by cmd keep running.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.Cancel = func() error {
+		infof("Killing pid %d", cmd.Process.Pid)
+		return cmd.Process.Kill()
+	}
+}
+
+// killProcessGroup kills the process with pid.
+func killProcessGroup(pid int) {
+	if pid == 0 {
+		return
+	}
+	if p, err := os.FindProcess(pid); err == nil {
+		p.Kill()
+	}
+}
+
+// processAlive reports whether a process with pid exists.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in a new process group, so that the processes
+// cmd starts are stopped with it. Cancelling cmd's context sends SIGTERM to
+// the process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		infof("Sending SIGTERM to pid %d", cmd.Process.Pid)
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+	}
+}
+
+// killProcessGroup sends SIGKILL to the process group led by pid.
+func killProcessGroup(pid int) {
+	if pid != 0 {
+		syscall.Kill(-pid, syscall.SIGKILL)
+	}
+}
+
+// processAlive reports whether a process with pid exists.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
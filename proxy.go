@@ -2,10 +2,14 @@ package main
 
 import (
 	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -14,70 +18,173 @@ import (
 	"time"
 )
 
-func runProxy(addr string, target *url.URL, fileWatch *Broadcaster[fsEventBatch]) {
-	rp := httputil.NewSingleHostReverseProxy(target)
-	rp.ModifyResponse = injectScript
+// proxyOptions are the dependencies of the devserver HTTP server.
+type proxyOptions struct {
+	addr       string
+	target     *url.URL
+	stream     *eventStream
+	modules    *moduleGraph
+	control    *controlHandler
+	requests   *requestTracker
+	requestLog *requestLog
+	mocks      *mockServer
+	network    *networkSimulator
+	transport  http.RoundTripper // connects the reverse proxy to target
+	client     clientConfig
+}
+
+// newProxy creates the devserver HTTP server. It proxies requests to
+// opts.target, unless they match a stub route of opts.mocks, under the
+// simulated network conditions and serves the event stream, the control API
+// and the client scripts.
+func newProxy(opts proxyOptions) *http.Server {
+	rp := httputil.NewSingleHostReverseProxy(opts.target)
+	rp.Transport = opts.transport
+	director := rp.Director
+	rp.Director = func(r *http.Request) {
+		director(r)
+		r.Header.Del(replayHeader)
+	}
+	rp.ModifyResponse = func(resp *http.Response) error {
+		if err := opts.modules.ModifyResponse(resp); err != nil {
+			return err
+		}
+		return injectScript(resp, opts.client)
+	}
 
 	mux := http.NewServeMux()
-	mux.Handle("/", rp)
-	mux.Handle("/_dev", &watchHandler{fileWatch})
+	mux.Handle("/", opts.requests.Wrap(opts.requestLog.Wrap(opts.network.Wrap(opts.mocks.Wrap(rp)))))
+	mux.Handle(requestsPath, opts.requestLog)
+	mux.Handle(requestsPath+"/", opts.requestLog)
+	mux.Handle("/_dev", &watchHandler{stream: opts.stream})
+	mux.Handle(controlPrefix, opts.control)
+	mux.Handle(hmrRuntimePath, scriptHandler(hmrRuntime))
+	mux.Handle(toolbarPath, scriptHandler(toolbarScript))
+	mux.Handle(overlayPath, scriptHandler(overlayScript))
 
-	srv := http.Server{
-		Addr:              addr,
+	return &http.Server{
+		Addr:              opts.addr,
 		Handler:           mux,
 		ReadHeaderTimeout: 1 * time.Minute,
 		IdleTimeout:       1 * time.Minute,
 		MaxHeaderBytes:    8 * (1 << 10), // 8K
 	}
+}
 
-	if err := srv.ListenAndServe(); err != nil {
-		log.Fatalf("proxy: error running server: %v", err)
+// newUpstreamTransport returns the transport of the reverse proxy. It
+// connects to up, with h2c it talks HTTP/2 without TLS to the upstream, which
+// must support HTTP/2 with prior knowledge.
+func newUpstreamTransport(up upstream, h2c bool) *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if up.Network == "unix" {
+		t.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return up.Dial(ctx)
+		}
+	}
+	if h2c {
+		var p http.Protocols
+		p.SetUnencryptedHTTP2(true)
+		t.Protocols = &p
 	}
+	return t
 }
 
-const reloadJs = `
-<script type="module" defer>
-	const es = new EventSource("/_dev");
-	es.addEventListener("change", (e) => {
-		const data = JSON.parse(e.data)
-		console.info("change event", e.data);
-
-		for (const {File: file, Ext: ext, Events: events} of data.events) {
-			const isCss = ext === ".css";
-			const isUpdated = events.includes("Updated");
-
-			if (isCss && isUpdated) {
-				for (const link of document.getElementsByTagName("link")) {
-					const url = new URL(link.href)
-
-					if (url.host === location.host && url.pathname === file) {
-						const next = link.cloneNode();
-						next.href = file + '?' + Math.random().toString(36).slice(2);
-						next.onload = () => link.remove();
-						link.parentNode.insertBefore(next, link.nextSibling);
-						console.info("replaced css", { old: link, new: next });
-						return
-					}
-				}
-			}
-		}
+// serverProtocols returns the protocols of the devserver listener: HTTP/1
+// and, over TLS, HTTP/2. With h2c HTTP/2 is accepted without TLS too.
+func serverProtocols(h2c bool) *http.Protocols {
+	var p http.Protocols
+	p.SetHTTP1(true)
+	p.SetHTTP2(true)
+	p.SetUnencryptedHTTP2(h2c)
+	return &p
+}
+
+// depsHeader lists the files (templates, assets) a page depends on. It is set
+// by the upstream server, multiple values or comma separated values are
+// accepted.
+const depsHeader = "X-Devserver-Deps"
+
+//go:embed reload.js
+var reloadScript string
+
+var reloadJs = jsonScript("devserver-assets", assetExts) + "\n<script type=\"module\" defer data-devserver>\n" + reloadScript + "</script>"
+
+// clientConfig configures the behaviour of reloadJs.
+type clientConfig struct {
+	// Morph updates the DOM in place instead of reloading the page on
+	// template changes and restarts.
+	Morph bool `json:"morph"`
+	// Toolbar shows the status toolbar.
+	Toolbar bool `json:"toolbar"`
+	// Overlay shows server errors in an overlay.
+	Overlay bool `json:"overlay"`
+}
 
-		console.info("reloading due to file change")
-		window.location.reload();
-	});
-</script>`
+// toolbarPath is the URL of the status toolbar module loaded by reloadJs.
+const toolbarPath = "/_dev/toolbar.js"
 
-func injectScript(resp *http.Response) error {
+//go:embed toolbar.js
+var toolbarScript []byte
+
+// overlayPath is the URL of the error overlay module loaded by reloadJs.
+const overlayPath = "/_dev/overlay.js"
+
+//go:embed overlay.js
+var overlayScript []byte
+
+// scriptHandler serves the JavaScript module src.
+func scriptHandler(src []byte) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("content-type", "text/javascript; charset=utf-8")
+		w.Header().Set("cache-control", "no-cache")
+		w.Write(src)
+	})
+}
+
+func injectScript(resp *http.Response, cfg clientConfig) error {
 	if !strings.HasPrefix(resp.Header.Get("content-type"), "text/html") {
 		return nil
 	}
 
+	script := jsonScript("devserver-config", cfg) + reloadJs
+	if deps := parseDeps(resp.Header.Values(depsHeader)); deps != nil {
+		script = jsonScript("devserver-deps", deps) + script
+	}
+	resp.Header.Del(depsHeader)
+
 	// Let the reverse proxy figure out the Content-Length
 	resp.Header.Del("content-length")
-	resp.Body = newInjectingReader(resp.Body, reloadJs)
+	resp.Body = newInjectingReader(resp.Body, script)
 	return nil
 }
 
+// parseDeps parses the values of the depsHeader. It returns nil if no
+// dependencies were declared.
+func parseDeps(values []string) []string {
+	var deps []string
+	for _, v := range values {
+		for dep := range strings.SplitSeq(v, ",") {
+			if dep = strings.TrimSpace(dep); dep != "" {
+				deps = append(deps, dep)
+			}
+		}
+	}
+	return deps
+}
+
+// jsonScript returns a JSON script tag with the given id that holds v for
+// reloadJs.
+func jsonScript(id string, v any) string {
+	// json.Marshal escapes <, > and & so the data cannot close the script
+	// tag.
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("proxy: json encode error: %v", err)
+		return ""
+	}
+	return `<script type="application/json" id="` + id + `" data-devserver>` + string(data) + `</script>`
+}
+
 const bodyEndMarker = "</body>"
 
 var _ io.ReadCloser = (*injectingReader)(nil)
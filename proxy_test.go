@@ -2,10 +2,17 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestInjectingReader_InjectBeforeBodyTag(t *testing.T) {
@@ -190,3 +197,200 @@ func assertInject(t *testing.T, r io.Reader, injected string) []byte {
 
 	return res
 }
+
+func TestParseDeps(t *testing.T) {
+	tests := []struct {
+		values []string
+		want   []string
+	}{
+		{nil, nil},
+		{[]string{""}, nil},
+		{[]string{"templates/index.tmpl"}, []string{"templates/index.tmpl"}},
+		{[]string{"a.tmpl, b.tmpl", "c.tmpl"}, []string{"a.tmpl", "b.tmpl", "c.tmpl"}},
+	}
+
+	for _, tt := range tests {
+		if got := parseDeps(tt.values); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseDeps(%q)\nwant: %q\ngot:  %q", tt.values, tt.want, got)
+		}
+	}
+}
+
+func TestInjectScript_Deps(t *testing.T) {
+	resp := &http.Response{
+		Header: http.Header{},
+		Body:   io.NopCloser(strings.NewReader("<html><body></body></html>")),
+	}
+	resp.Header.Set("content-type", "text/html")
+	resp.Header.Set(depsHeader, "templates/index.tmpl, </script>")
+
+	if err := injectScript(resp, clientConfig{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if resp.Header.Get(depsHeader) != "" {
+		t.Errorf("expected %s header to be removed", depsHeader)
+	}
+
+	res := assertInject(t, resp.Body, reloadJs)
+	want := `<script type="application/json" id="devserver-deps" data-devserver>["templates/index.tmpl","\u003c/script\u003e"]</script>`
+	if !bytes.Contains(res, []byte(want)) {
+		t.Errorf("expected to find dependencies\nwant: %s\ngot:  %s", want, res)
+	}
+}
+
+// testProxyOptions returns the options of a proxy to target with default
+// dependencies. Tests replace the ones they exercise.
+func testProxyOptions(target *url.URL) proxyOptions {
+	control, _ := newTestControlHandler()
+	return proxyOptions{
+		target:     target,
+		stream:     control.stream,
+		modules:    newModuleGraph(),
+		control:    control,
+		requests:   newRequestTracker(),
+		requestLog: newRequestLog(control.stream, 10, 0),
+		mocks:      newMockServer(""),
+		network:    newNetworkSimulator(netConditions{}),
+		transport:  http.DefaultTransport,
+	}
+}
+
+func TestNewProxy_H2C(t *testing.T) {
+	app := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.Proto)
+	}))
+	app.Config.Protocols = new(http.Protocols)
+	app.Config.Protocols.SetUnencryptedHTTP2(true)
+	app.Start()
+	defer app.Close()
+	target, _ := url.Parse(app.URL)
+
+	opts := testProxyOptions(target)
+	opts.transport = newUpstreamTransport(upstream{Network: "tcp", Addr: target.Host}, true)
+	proxy := newProxy(opts)
+	srv := httptest.NewUnstartedServer(proxy.Handler)
+	srv.Config.Protocols = serverProtocols(true)
+	srv.Start()
+	defer srv.Close()
+
+	clientProtocols := new(http.Protocols)
+	clientProtocols.SetUnencryptedHTTP2(true)
+	client := &http.Client{Transport: &http.Transport{Protocols: clientProtocols}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.Proto != "HTTP/2.0" || string(body) != "HTTP/2.0" {
+		t.Errorf("expected HTTP/2 on both sides, got %s to devserver and %s to the upstream", resp.Proto, body)
+	}
+}
+
+func TestNewProxy_Replay(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "app")
+		if r.Header.Get(replayHeader) != "" {
+			t.Errorf("expected %s to be removed", replayHeader)
+		}
+	}))
+	defer app.Close()
+	target, _ := url.Parse(app.URL)
+
+	mocksFile := filepath.Join(t.TempDir(), "mocks.json")
+	os.WriteFile(mocksFile, []byte(`{"routes": [{"path": "/mocked", "body": "mock"}]}`), 0o644)
+	mocks := newMockServer(mocksFile)
+	if _, err := mocks.Load(); err != nil {
+		t.Fatal(err)
+	}
+	network := newNetworkSimulator(netConditions{Enabled: true, Faults: []netFault{{Kind: "503", Rate: 1}}})
+
+	opts := testProxyOptions(target)
+	opts.mocks, opts.network = mocks, network
+	proxy := newProxy(opts)
+
+	get := func(replay bool) (int, string) {
+		req := httptest.NewRequest("GET", "/mocked", nil)
+		if replay {
+			req.Header.Set(replayHeader, "1")
+		}
+		rec := httptest.NewRecorder()
+		proxy.Handler.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	if code, _ := get(false); code != http.StatusServiceUnavailable {
+		t.Errorf("expected the fault to be injected, got %d", code)
+	}
+	network.Set(netConditions{})
+	if code, body := get(false); code != http.StatusOK || body != "mock" {
+		t.Errorf("expected the mock, got %d %q", code, body)
+	}
+	network.Set(netConditions{Enabled: true, Faults: []netFault{{Kind: "503", Rate: 1}}})
+
+	// Replayed requests go to the server as they are and are not recorded.
+	if code, body := get(true); code != http.StatusOK || body != "app" {
+		t.Errorf("expected the response of the server, got %d %q", code, body)
+	}
+	if n := len(opts.requestLog.Entries()); n != 2 {
+		t.Errorf("expected 2 recorded requests, got %d", n)
+	}
+}
+
+func TestNewProxy_Faults(t *testing.T) {
+	app := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "app")
+	}))
+	defer app.Close()
+	target, _ := url.Parse(app.URL)
+
+	opts := testProxyOptions(target)
+	opts.network = newNetworkSimulator(netConditions{
+		Enabled: true,
+		Faults: []netFault{
+			{Path: "/reset", Kind: faultReset, Rate: 1},
+			{Path: "/unavailable", Kind: "503", Rate: 1},
+		},
+	})
+	proxy := newProxy(opts)
+	srv := httptest.NewServer(proxy.Handler)
+	defer srv.Close()
+
+	// Without keep-alive the client does not retry the reset request on a
+	// new connection.
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+	for _, path := range []string{"/ok", "/unavailable", "/reset"} {
+		resp, err := client.Get(srv.URL + path)
+		if path == "/reset" {
+			if err == nil {
+				resp.Body.Close()
+				t.Fatalf("expected the connection to be reset, got %s", resp.Status)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+
+	// The reset request is recorded after the connection is closed.
+	var entries []*requestEntry
+	for range 100 {
+		if entries = opts.requestLog.Entries(); len(entries) == 3 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var got []string
+	for _, e := range entries {
+		got = append(got, fmt.Sprintf("%s %d %s", e.URL, e.Status, e.Fault))
+	}
+	want := []string{"/ok 200 ", "/unavailable 503 503", "/reset 0 reset"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected entries\nwant: %q\ngot:  %q", want, got)
+	}
+}
@@ -0,0 +1,554 @@
+// reload.js is injected into every HTML page served through devserver. It
+// listens to the /_dev event stream and reloads the page or swaps changed
+// assets in place.
+
+const config = JSON.parse(document.getElementById("devserver-config")?.textContent ?? "{}");
+let deps = JSON.parse(document.getElementById("devserver-deps")?.textContent ?? "null");
+
+// Extensions of files that can be swapped without reloading the page.
+const assetExts = JSON.parse(document.getElementById("devserver-assets")?.textContent ?? "[]");
+
+// affectsPage reports whether a change to file requires reloading the
+// page. Pages without dependency information are always reloaded.
+const affectsPage = (file) => {
+	if (deps === null) {
+		return true;
+	}
+
+	const assets = [];
+	for (const el of document.querySelectorAll("script[src], link[href], img[src]")) {
+		const url = new URL(el.src || el.href);
+		if (url.host === location.host) {
+			assets.push(url.pathname);
+		}
+	}
+
+	return [...deps, ...assets].some((dep) => {
+		return file === dep || file.endsWith("/" + dep.replace(/^\/+/, ""));
+	});
+};
+
+// matches reports whether url points to file on this host.
+const matches = (url, file, base = location.href) => {
+	try {
+		const u = new URL(url, base);
+		return u.host === location.host && u.pathname === file;
+	} catch {
+		return false;
+	}
+};
+
+// bust returns url with a random query parameter to bypass the cache.
+const bust = (url, base = location.href) => {
+	const u = new URL(url, base);
+	u.searchParams.set("_dev", Math.random().toString(36).slice(2));
+	return u.href;
+};
+
+// bustSrcset rewrites the URLs in a srcset attribute that point to file.
+const bustSrcset = (srcset, file) => {
+	let changed = false;
+	const next = srcset.split(",").map((candidate) => {
+		const [url, ...descriptors] = candidate.trim().split(/\s+/);
+		if (!matches(url, file)) {
+			return candidate;
+		}
+		changed = true;
+		return [bust(url), ...descriptors].join(" ");
+	}).join(", ");
+	return changed ? next : null;
+};
+
+// bustCssUrls rewrites the url() references in a CSS value that point to
+// file.
+const bustCssUrls = (value, file, base) => {
+	let changed = false;
+	const next = value.replace(/url\((['"]?)([^'")]+)\1\)/g, (match, quote, url) => {
+		if (!matches(url, file, base)) {
+			return match;
+		}
+		changed = true;
+		return `url("${bust(url, base)}")`;
+	});
+	return changed ? next : null;
+};
+
+// bustStyle rewrites the url() references in every property of style.
+const bustStyle = (style, file, base) => {
+	let swapped = false;
+	for (let i = 0; i < style.length; i++) {
+		const name = style[i];
+		const value = bustCssUrls(style.getPropertyValue(name), file, base);
+		if (value !== null) {
+			style.setProperty(name, value, style.getPropertyPriority(name));
+			swapped = true;
+		}
+	}
+	return swapped;
+};
+
+// bustRules walks the CSS rules recursively, including @font-face and
+// @media rules, and rewrites url() references that point to file.
+const bustRules = (rules, file, base) => {
+	let swapped = false;
+	for (const rule of rules) {
+		if (rule.style) {
+			swapped = bustStyle(rule.style, file, base) || swapped;
+		}
+		if (rule.cssRules) {
+			swapped = bustRules(rule.cssRules, file, base) || swapped;
+		}
+	}
+	return swapped;
+};
+
+// swapCss replaces the stylesheet links pointing to file with a fresh copy.
+const swapCss = (file) => {
+	for (const link of document.getElementsByTagName("link")) {
+		if (matches(link.href, file)) {
+			const next = link.cloneNode();
+			next.href = bust(link.href);
+			next.onload = () => link.remove();
+			link.parentNode.insertBefore(next, link.nextSibling);
+			console.info("replaced css", { old: link, new: next });
+			return true;
+		}
+	}
+	return false;
+};
+
+// swapAsset reloads images, fonts and other static assets pointing to file
+// in place.
+const swapAsset = (file) => {
+	let swapped = false;
+
+	for (const el of document.querySelectorAll("img, source, video, audio, input[type=image]")) {
+		if (el.getAttribute("src") !== null && matches(el.src, file)) {
+			el.src = bust(el.src);
+			swapped = true;
+		}
+		const srcset = el.getAttribute("srcset");
+		if (srcset !== null) {
+			const next = bustSrcset(srcset, file);
+			if (next !== null) {
+				el.srcset = next;
+				swapped = true;
+			}
+		}
+	}
+
+	for (const el of document.querySelectorAll("[style*='url(']")) {
+		swapped = bustStyle(el.style, file, location.href) || swapped;
+	}
+
+	for (const sheet of document.styleSheets) {
+		let rules;
+		try {
+			rules = sheet.cssRules;
+		} catch {
+			continue; // cross-origin stylesheet
+		}
+		swapped = bustRules(rules, file, sheet.href ?? location.href) || swapped;
+	}
+
+	if (swapped) {
+		console.info("replaced asset", file);
+	}
+	return swapped;
+};
+
+// swapModule re-imports the modules accepting the update of a JavaScript
+// module.
+const swapModule = async (modules) => {
+	try {
+		const { apply } = await import("/_dev/hmr.js");
+		return await apply(modules);
+	} catch (err) {
+		console.error("hot update failed", err);
+		return false;
+	}
+};
+
+// swap tries to apply the change without reloading the page. It returns
+// true if the change was handled.
+const swap = async (event) => {
+	const {File: file, Ext: ext, Events: events, Stylesheets: stylesheets = [], Modules: modules = []} = event;
+	if (!events.includes("Updated")) {
+		return false;
+	}
+	// Modules lists the modules accepting the update, see import.meta.hot.
+	if (ext === ".js" && modules.length > 0) {
+		return swapModule(modules);
+	}
+	// Stylesheets lists the stylesheets importing the file or compiled from
+	// it.
+	const sheets = ext === ".css" ? [file, ...stylesheets] : stylesheets;
+	if (sheets.length > 0) {
+		return sheets.map(swapCss).some(Boolean);
+	}
+	// An asset the page does not use needs no reload either.
+	if (assetExts.includes(ext)) {
+		swapAsset(file);
+		return true;
+	}
+	return false;
+};
+
+// Page state (scroll positions, form values, open <details>) is saved to
+// sessionStorage before a reload and restored afterwards. Add the
+// data-devserver-no-preserve attribute to an element to exclude it and its
+// descendants, or to <html> or <body> to disable preserving state.
+const noPreserve = "data-devserver-no-preserve";
+const stateKey = "devserver-state:" + location.pathname + location.search;
+
+const preserveDisabled = () => {
+	return document.documentElement.hasAttribute(noPreserve) || document.body?.hasAttribute(noPreserve);
+};
+
+const excluded = (el) => el.closest(`[${noPreserve}]`) !== null;
+
+// selector returns a CSS selector identifying el in the document.
+const selector = (el) => {
+	const parts = [];
+	for (; el && el !== document.documentElement; el = el.parentElement) {
+		if (el.id) {
+			parts.unshift("#" + CSS.escape(el.id));
+			break;
+		}
+		const index = Array.prototype.indexOf.call(el.parentElement.children, el) + 1;
+		parts.unshift(`${el.tagName.toLowerCase()}:nth-child(${index})`);
+	}
+	return parts.join(" > ");
+};
+
+// fieldKeys returns a stable key for every form field, based on the id or
+// the name of the field. Fields sharing a name, e.g. radio buttons, are
+// distinguished by their position.
+const fieldKeys = () => {
+	const keys = new Map();
+	const seen = new Map();
+	for (const el of document.querySelectorAll("input, textarea, select")) {
+		if (excluded(el) || ["password", "file", "hidden", "submit", "button", "reset", "image"].includes(el.type)) {
+			continue;
+		}
+		if (el.id) {
+			keys.set("#" + el.id, el);
+		} else if (el.name) {
+			const n = seen.get(el.name) ?? 0;
+			seen.set(el.name, n + 1);
+			keys.set(`${el.form ? selector(el.form) : ""}[name=${el.name}]:${n}`, el);
+		}
+	}
+	return keys;
+};
+
+const saveState = () => {
+	if (preserveDisabled()) {
+		return;
+	}
+
+	const state = {
+		scroll: [window.scrollX, window.scrollY],
+		containers: [],
+		fields: [],
+		details: [],
+	};
+
+	for (const el of document.body.querySelectorAll("*")) {
+		if ((el.scrollTop > 0 || el.scrollLeft > 0) && !excluded(el)) {
+			state.containers.push([selector(el), el.scrollLeft, el.scrollTop]);
+		}
+	}
+
+	for (const [key, el] of fieldKeys()) {
+		if (el.type === "checkbox" || el.type === "radio") {
+			state.fields.push([key, el.checked]);
+		} else if (el.multiple) {
+			state.fields.push([key, Array.from(el.selectedOptions, (o) => o.value)]);
+		} else {
+			state.fields.push([key, el.value]);
+		}
+	}
+
+	for (const el of document.querySelectorAll("details[open]")) {
+		if (!excluded(el)) {
+			state.details.push(selector(el));
+		}
+	}
+
+	try {
+		sessionStorage.setItem(stateKey, JSON.stringify(state));
+	} catch (err) {
+		console.warn("failed to save page state", err);
+	}
+};
+
+const restoreState = () => {
+	const saved = sessionStorage.getItem(stateKey);
+	if (saved === null) {
+		return;
+	}
+	sessionStorage.removeItem(stateKey);
+	if (preserveDisabled()) {
+		return;
+	}
+
+	const state = JSON.parse(saved);
+
+	for (const sel of state.details) {
+		const el = document.querySelector(sel);
+		if (el instanceof HTMLDetailsElement) {
+			el.open = true;
+		}
+	}
+
+	const fields = fieldKeys();
+	for (const [key, value] of state.fields) {
+		const el = fields.get(key);
+		if (!el) {
+			continue;
+		}
+		if (el.type === "checkbox" || el.type === "radio") {
+			el.checked = value;
+		} else if (el.multiple) {
+			for (const o of el.options) {
+				o.selected = value.includes(o.value);
+			}
+		} else {
+			el.value = value;
+		}
+	}
+
+	for (const [sel, left, top] of state.containers) {
+		document.querySelector(sel)?.scrollTo(left, top);
+	}
+	window.scrollTo(...state.scroll);
+};
+
+if (document.readyState === "complete") {
+	requestAnimationFrame(restoreState);
+} else {
+	window.addEventListener("load", () => requestAnimationFrame(restoreState), { once: true });
+}
+
+const reload = () => {
+	saveState();
+	window.location.reload();
+};
+
+// Elements injected by devserver are marked with the data-devserver
+// attribute. They are left alone when morphing.
+const isDevserver = (node) => node.nodeType === Node.ELEMENT_NODE && node.hasAttribute("data-devserver");
+
+const sameNode = (a, b) => {
+	return a.nodeType === b.nodeType && a.nodeName === b.nodeName && (a.id ?? "") === (b.id ?? "");
+};
+
+const syncAttributes = (from, to) => {
+	for (const { name } of Array.from(from.attributes)) {
+		if (!to.hasAttribute(name)) {
+			from.removeAttribute(name);
+		}
+	}
+	for (const { name, value } of to.attributes) {
+		if (from.getAttribute(name) !== value) {
+			from.setAttribute(name, value);
+		}
+	}
+};
+
+// morphNode updates from in place to match to. Nodes that did not change are
+// kept, preserving focus, scroll position and JavaScript state attached to
+// them.
+const morphNode = (from, to) => {
+	if (from.nodeType !== Node.ELEMENT_NODE) {
+		if (from.nodeValue !== to.nodeValue) {
+			from.nodeValue = to.nodeValue;
+		}
+		return;
+	}
+	syncAttributes(from, to);
+	if (from instanceof HTMLTemplateElement) {
+		from.content.replaceChildren(document.importNode(to.content, true));
+		return;
+	}
+	morphChildren(from, to);
+};
+
+// morphChildren updates the children of from to match the children of to.
+// Elements are matched by id first, then by position and type.
+const morphChildren = (from, to) => {
+	const skip = (node) => {
+		while (node && isDevserver(node)) {
+			node = node.nextSibling;
+		}
+		return node;
+	};
+
+	let cur = skip(from.firstChild);
+	for (const next of Array.from(to.childNodes)) {
+		if (isDevserver(next)) {
+			continue;
+		}
+
+		let match = null;
+		if (next.id) {
+			match = Array.from(from.children).find((el) => el.id === next.id && el.nodeName === next.nodeName) ?? null;
+		} else if (cur && sameNode(cur, next)) {
+			match = cur;
+		}
+
+		if (match === null) {
+			from.insertBefore(document.importNode(next, true), cur);
+			continue;
+		}
+		if (match === cur) {
+			cur = skip(cur.nextSibling);
+		} else {
+			from.insertBefore(match, cur);
+		}
+		morphNode(match, next);
+	}
+
+	while (cur) {
+		const next = skip(cur.nextSibling);
+		cur.remove();
+		cur = next;
+	}
+};
+
+// headScripts returns a signature of the scripts in the head of doc.
+const headScripts = (doc) => {
+	return Array.from(doc.head.querySelectorAll("script:not([data-devserver])"), (s) => {
+		return [s.type, s.getAttribute("src"), s.textContent].join("\0");
+	}).join("\n");
+};
+
+// morph fetches the current page and merges it into the document. It falls
+// back to reloading the page when the scripts in <head> change.
+const morph = async () => {
+	let doc;
+	try {
+		const resp = await fetch(location.href, { headers: { "accept": "text/html" } });
+		if (!resp.ok) {
+			throw new Error(`unexpected status ${resp.status}`);
+		}
+		doc = new DOMParser().parseFromString(await resp.text(), "text/html");
+	} catch (err) {
+		console.warn("morph failed, reloading", err);
+		reload();
+		return;
+	}
+
+	if (headScripts(doc) !== headScripts(document)) {
+		console.info("scripts in head changed, reloading");
+		reload();
+		return;
+	}
+
+	const active = document.activeElement;
+	const selection = active && "selectionStart" in active ? [active.selectionStart, active.selectionEnd] : null;
+
+	morphNode(document.head, doc.head);
+	morphNode(document.body, doc.body);
+
+	const nextDeps = doc.getElementById("devserver-deps");
+	deps = JSON.parse(nextDeps?.textContent ?? "null");
+	const depsEl = document.getElementById("devserver-deps");
+	if (depsEl && nextDeps) {
+		depsEl.textContent = nextDeps.textContent;
+	}
+
+	if (active?.isConnected && document.activeElement !== active) {
+		active.focus({ preventScroll: true });
+		if (selection) {
+			try {
+				active.setSelectionRange(...selection);
+			} catch {
+				// not a text field
+			}
+		}
+	}
+	console.info("morphed page");
+};
+
+// canMorph reports whether the changes can be applied by morphing the DOM.
+const canMorph = (events) => {
+	return config.morph && events.every(({ Ext: ext }) => ext === ".tmpl" || ext === ".html");
+};
+
+// Only subscribe to the events the page handles, log lines and proxied
+// requests would fill the buffer of the connection. The toolbar shares the
+// connection and needs the status events.
+const events = ["change", "server-error", "shutdown", ...(config.toolbar ? ["status"] : [])];
+const es = new EventSource("/_dev?events=" + events.join(","));
+es.addEventListener("change", async (e) => {
+	const data = JSON.parse(e.data)
+	console.info("change event", e.data);
+
+	// An empty batch means the server was restarted.
+	if (data.events.length > 0) {
+		const rest = [];
+		for (const event of data.events) {
+			if (!await swap(event)) {
+				rest.push(event);
+			}
+		}
+		if (rest.length === 0) {
+			return;
+		}
+		if (!rest.some(({File: file}) => affectsPage(file))) {
+			console.info("ignoring file change, page does not depend on it");
+			return;
+		}
+		if (canMorph(rest)) {
+			await morph();
+			return;
+		}
+	} else if (config.morph) {
+		await morph();
+		return;
+	}
+
+	console.info("reloading due to file change")
+	reload();
+});
+
+// The server panicked or logged an error.
+es.addEventListener("server-error", (e) => {
+	const err = JSON.parse(e.data);
+	const request = err.request ? ` (${err.request.method} ${err.request.url})` : "";
+	console.error(`devserver: server ${err.kind}${request}\n${err.text}`);
+
+	if (config.overlay) {
+		import("/_dev/overlay.js")
+			.then(({ show }) => show(err))
+			.catch((err) => console.error("failed to load devserver error overlay", err));
+	}
+});
+
+// devserver is going away. Stop reconnecting to the event stream and reload
+// the page once devserver is back.
+es.addEventListener("shutdown", () => {
+	console.info("devserver stopped");
+	es.close();
+	const poll = async () => {
+		try {
+			const resp = await fetch("/_dev/api/status");
+			if (resp.ok) {
+				reload();
+				return;
+			}
+		} catch {
+			// devserver is not running yet
+		}
+		setTimeout(poll, 1000);
+	};
+	setTimeout(poll, 1000);
+});
+
+if (config.toolbar) {
+	import("/_dev/toolbar.js")
+		.then(({ mount }) => mount(es))
+		.catch((err) => console.error("failed to load devserver toolbar", err));
+}
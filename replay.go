@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// replayHeader marks the requests sent by the replayer. They bypass the stub
+// routes, the network simulator and the request log, so that the responses
+// are the ones of the server and replaying does not record new requests.
+const replayHeader = "X-Devserver-Replay"
+
+// isReplay reports whether r was sent by the replayer.
+func isReplay(r *http.Request) bool {
+	return r.Header.Get(replayHeader) != ""
+}
+
+// defaultIgnoredHeaders are response headers that differ between otherwise
+// identical responses. Content-Length is not recorded if net/http sets it, the
+// length is checked by comparing the bodies.
+var defaultIgnoredHeaders = []string{"Age", "Content-Length", "Date", "Expires", requestIDHeader}
+
+// Request headers not sent when replaying a request. Hop-by-hop headers and
+// the ones set by the client.
+var replaySkipHeaders = []string{
+	"Connection", "Content-Length", "Host", "Keep-Alive", "Proxy-Authorization",
+	"Proxy-Connection", "Te", "Trailer", "Transfer-Encoding", "Upgrade",
+	requestIDHeader,
+}
+
+// moduleVersionRe matches the version query parameter moduleGraph.Record adds
+// to the imports of updated JavaScript modules, followed by the closing quote
+// of the specifier.
+var moduleVersionRe = regexp.MustCompile(`\?v=\d+(["'])`)
+
+const (
+	// maxDiffLines limits the number of lines of a body diff.
+	maxDiffLines = 50
+	// maxDiffCells limits the size of the table used to diff bodies,
+	// larger bodies are only reported as different.
+	maxDiffCells = 4_000_000
+)
+
+// replayer re-sends recorded requests and compares the responses with the
+// recorded ones.
+type replayer struct {
+	client *http.Client
+	target *url.URL
+	// ignore lists the canonical names of the response headers not
+	// compared.
+	ignore []string
+}
+
+// newReplayer creates a replayer sending the requests to target. tlsConfig
+// configures the connections to an https target, it may be nil.
+func newReplayer(target *url.URL, ignore []string, tlsConfig *tls.Config) *replayer {
+	r := &replayer{
+		client: &http.Client{
+			// Compare the responses as they are sent.
+			Transport: &http.Transport{DisableCompression: true, TLSClientConfig: tlsConfig},
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		target: target,
+	}
+	for _, h := range slices.Concat(defaultIgnoredHeaders, ignore) {
+		r.ignore = append(r.ignore, http.CanonicalHeaderKey(h))
+	}
+	return r
+}
+
+// replayResult is the outcome of replaying a harEntry.
+type replayResult struct {
+	Method, URL string
+	Skipped     string   // reason the request was not replayed
+	Err         error    // the request failed
+	Diff        []string // differences of the responses
+}
+
+// Run replays every entry of har and writes the results to w. It returns the
+// number of requests that failed or whose response differs.
+func (r *replayer) Run(har harFile, w io.Writer) int {
+	var ok, differ, skipped, failed int
+	for _, e := range har.Log.Entries {
+		res := r.Replay(e)
+		target := res.Method + " " + res.URL
+		switch {
+		case res.Skipped != "":
+			skipped++
+			fmt.Fprintf(w, "skip  %s: %s\n", target, res.Skipped)
+		case res.Err != nil:
+			failed++
+			fmt.Fprintf(w, "error %s: %v\n", target, res.Err)
+		case len(res.Diff) > 0:
+			differ++
+			fmt.Fprintf(w, "diff  %s\n", target)
+			for _, line := range res.Diff {
+				fmt.Fprintf(w, "      %s\n", line)
+			}
+		default:
+			ok++
+			fmt.Fprintf(w, "ok    %s %d\n", target, e.Response.Status)
+		}
+	}
+	fmt.Fprintf(w, "\n%d requests: %d ok, %d differ, %d skipped, %d failed\n", len(har.Log.Entries), ok, differ, skipped, failed)
+	return differ + failed
+}
+
+// Replay sends the request of e to the target and compares the response to
+// the recorded one.
+func (r *replayer) Replay(e harEntry) replayResult {
+	res := replayResult{Method: e.Request.Method, URL: e.Request.URL}
+
+	u, err := url.Parse(e.Request.URL)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	res.URL = u.RequestURI()
+	u.Scheme, u.Host = r.target.Scheme, r.target.Host
+	u.Path = strings.TrimSuffix(r.target.Path, "/") + u.Path
+	u.RawPath = ""
+
+	var body io.Reader
+	if pd := e.Request.PostData; pd != nil {
+		if pd.Truncated {
+			res.Skipped = "request body was only captured partially"
+			return res
+		}
+		b, err := decodeHARText(pd.Text, pd.Encoding)
+		if err != nil {
+			res.Err = err
+			return res
+		}
+		body = bytes.NewReader(b)
+	} else if e.Request.BodySize > 0 {
+		res.Skipped = "request body was not captured"
+		return res
+	}
+
+	req, err := http.NewRequest(e.Request.Method, u.String(), body)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	for name, values := range httpHeader(e.Request.Headers) {
+		if !slices.Contains(replaySkipHeaders, http.CanonicalHeaderKey(name)) {
+			req.Header[name] = values
+		}
+	}
+	req.Header.Set(replayHeader, "1")
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+	defer resp.Body.Close()
+	got, err := io.ReadAll(resp.Body)
+	if err != nil {
+		res.Err = err
+		return res
+	}
+
+	if want := e.Response.Status; resp.StatusCode != want {
+		res.Diff = append(res.Diff, fmt.Sprintf("status: %d → %d", want, resp.StatusCode))
+	}
+	res.Diff = append(res.Diff, r.diffHeaders(httpHeader(e.Response.Headers), resp.Header)...)
+	res.Diff = append(res.Diff, diffBodies(e.Response.Content, got)...)
+	return res
+}
+
+func (r *replayer) diffHeaders(want, got http.Header) []string {
+	var names []string
+	for name := range want {
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	for name := range got {
+		names = append(names, http.CanonicalHeaderKey(name))
+	}
+	slices.Sort(names)
+	names = slices.Compact(names)
+
+	var diff []string
+	for _, name := range names {
+		if slices.Contains(r.ignore, name) {
+			continue
+		}
+		w, g := strings.Join(want.Values(name), ", "), strings.Join(got.Values(name), ", ")
+		switch {
+		case w == g:
+		case len(want.Values(name)) == 0:
+			diff = append(diff, fmt.Sprintf("header %s: added %q", name, g))
+		case len(got.Values(name)) == 0:
+			diff = append(diff, fmt.Sprintf("header %s: removed %q", name, w))
+		default:
+			diff = append(diff, fmt.Sprintf("header %s: %q → %q", name, w, g))
+		}
+	}
+	return diff
+}
+
+// diffBodies compares the recorded content with the body of the replayed
+// response. Content that was not recorded is not compared, partially
+// recorded content is compared with the beginning of the body. The versions
+// of hot replaced modules in JavaScript imports are ignored.
+func diffBodies(content harContent, got []byte) []string {
+	if content.Text == "" && content.Size > 0 {
+		return nil
+	}
+	want, err := decodeHARText(content.Text, content.Encoding)
+	if err != nil {
+		return []string{fmt.Sprintf("body: %v", err)}
+	}
+	if isJavaScript(content.MimeType) {
+		want = moduleVersionRe.ReplaceAll(want, []byte("$1"))
+		got = moduleVersionRe.ReplaceAll(got, []byte("$1"))
+	}
+	if content.Truncated && len(got) > len(want) {
+		got = got[:len(want)]
+	}
+	if bytes.Equal(want, got) {
+		return nil
+	}
+
+	if !utf8.Valid(want) || !utf8.Valid(got) {
+		return []string{fmt.Sprintf("body: binary content differs, %d → %d bytes", len(want), len(got))}
+	}
+	diff := []string{"body:"}
+	lines := diffLines(strings.Split(string(want), "\n"), strings.Split(string(got), "\n"))
+	if lines == nil {
+		return append(diff, fmt.Sprintf("content differs, %d → %d bytes", len(want), len(got)))
+	}
+	if len(lines) > maxDiffLines {
+		lines = append(lines[:maxDiffLines], fmt.Sprintf("… %d more lines", len(lines)-maxDiffLines))
+	}
+	return append(diff, lines...)
+}
+
+// diffLines returns the lines removed from a, prefixed with "- ", and the
+// lines added in b, prefixed with "+ ", in order. It returns nil if a and b
+// are too large to compare.
+func diffLines(a, b []string) []string {
+	if len(a)*len(b) > maxDiffCells {
+		return nil
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of a[i:]
+	// and b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	diff := []string{}
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			diff = append(diff, "- "+a[i])
+			i++
+		default:
+			diff = append(diff, "+ "+b[j])
+			j++
+		}
+	}
+	return diff
+}
+
+func decodeHARText(text, encoding string) ([]byte, error) {
+	switch encoding {
+	case "":
+		return []byte(text), nil
+	case "base64":
+		return base64.StdEncoding.DecodeString(text)
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", encoding)
+}
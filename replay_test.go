@@ -0,0 +1,143 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestReplayer_Replay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get(requestIDHeader) != "" {
+			t.Errorf("unexpected %s header", requestIDHeader)
+		}
+		if !isReplay(r) {
+			t.Errorf("expected the %s header", replayHeader)
+		}
+		b, _ := io.ReadAll(r.Body)
+		w.Header().Set("content-type", "text/plain")
+		w.Header().Set("date", "now")
+		switch r.URL.Path {
+		case "/same":
+			io.WriteString(w, "a\nb\n")
+		case "/echo":
+			w.Write(b)
+		case "/module":
+			w.Header().Set("content-type", "text/javascript")
+			io.WriteString(w, `import "./a.js?v=2";`+"\n")
+		case "/changed":
+			w.Header().Set("x-version", "2")
+			w.WriteHeader(http.StatusAccepted)
+			io.WriteString(w, "a\nc\n")
+		}
+	}))
+	defer srv.Close()
+	target, _ := url.Parse(srv.URL)
+
+	entry := func(method, path, body, want string) harEntry {
+		e := harEntry{
+			Request: harRequest{
+				Method:  method,
+				URL:     "http://localhost:8080" + path,
+				Headers: []harNameValue{{requestIDHeader, "req-1"}, {"Host", "localhost:8080"}},
+			},
+			Response: harResponse{
+				Status: http.StatusOK,
+				Headers: []harNameValue{
+					{"Content-Length", strconv.Itoa(len(want))},
+					{"Content-Type", "text/plain"},
+					{"Date", "yesterday"},
+				},
+				Content: harContent{Size: int64(len(want)), Text: want},
+			},
+		}
+		if body != "" {
+			e.Request.BodySize = int64(len(body))
+			e.Request.PostData = &harPostData{Text: body}
+		}
+		return e
+	}
+
+	tests := []struct {
+		name    string
+		entry   harEntry
+		diff    []string
+		skipped bool
+	}{
+		{"same", entry("GET", "/same", "", "a\nb\n"), nil, false},
+		{"echo", entry("POST", "/echo", "hello", "hello"), nil, false},
+		{"changed", entry("GET", "/changed", "", "a\nb\n"), []string{
+			"status: 200 → 202",
+			`header X-Version: added "2"`,
+			"body:",
+			"- b",
+			"+ c",
+		}, false},
+		{"truncated", func() harEntry {
+			e := entry("GET", "/same", "", "a\n")
+			e.Response.Headers[0].Value = "4"
+			e.Response.Content.Truncated = true
+			return e
+		}(), nil, false},
+		{"module versions", func() harEntry {
+			e := entry("GET", "/module", "", `import "./a.js?v=1";`+"\n")
+			e.Response.Headers[1].Value = "text/javascript"
+			e.Response.Content.MimeType = "text/javascript"
+			return e
+		}(), nil, false},
+		{"body not captured", func() harEntry {
+			e := entry("POST", "/echo", "", "")
+			e.Request.BodySize = 10
+			return e
+		}(), nil, true},
+	}
+
+	r := newReplayer(target, nil, nil)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := r.Replay(tt.entry)
+			if res.Err != nil {
+				t.Fatalf("unexpected error: %v", res.Err)
+			}
+			if (res.Skipped != "") != tt.skipped {
+				t.Errorf("unexpected skip reason %q", res.Skipped)
+			}
+			if !reflect.DeepEqual(res.Diff, tt.diff) {
+				t.Errorf("unexpected diff\nwant: %q\ngot:  %q", tt.diff, res.Diff)
+			}
+		})
+	}
+
+	var out strings.Builder
+	har := harFile{Log: harLog{Entries: []harEntry{tests[0].entry, tests[2].entry}}}
+	if n := r.Run(har, &out); n != 1 {
+		t.Errorf("expected 1 difference, got %d\n%s", n, out.String())
+	}
+	if !strings.Contains(out.String(), "2 requests: 1 ok, 1 differ, 0 skipped, 0 failed") {
+		t.Errorf("unexpected summary:\n%s", out.String())
+	}
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want []string
+	}{
+		{"a b c", "a b c", []string{}},
+		{"a b c", "a x c", []string{"- b", "+ x"}},
+		{"a b", "a b c", []string{"+ c"}},
+		{"a b c", "b c", []string{"- a"}},
+	}
+
+	for _, tt := range tests {
+		got := diffLines(strings.Fields(tt.a), strings.Fields(tt.b))
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("diffLines(%q, %q)\nwant: %q\ngot:  %q", tt.a, tt.b, tt.want, got)
+		}
+	}
+}
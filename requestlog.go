@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// eventRequest events carry the requestSummary of a proxied request.
+const eventRequest = "request"
+
+// requestsPath is the URL of the request log inspector.
+const requestsPath = "/_dev/requests"
+
+//go:embed requests.html
+var requestsPage []byte
+
+// requestEntry is a proxied request and its response.
+type requestEntry struct {
+	requestSummary
+	RequestHeaders  http.Header   `json:"requestHeaders"`
+	ResponseHeaders http.Header   `json:"responseHeaders"`
+	RequestBody     *capturedBody `json:"requestBody,omitempty"`
+	ResponseBody    *capturedBody `json:"responseBody,omitempty"`
+}
+
+// requestSummary is the part of a requestEntry shown in lists.
+type requestSummary struct {
+	ID        uint64    `json:"id"`
+	RequestID string    `json:"requestID,omitempty"` // see requestIDHeader
+	Method    string    `json:"method"`
+	Host      string    `json:"host"`
+	URL       string    `json:"url"` // path and query
+	Proto     string    `json:"proto"`
+	TLS       bool      `json:"tls,omitempty"`
+	Status    int       `json:"status"`
+	Started   time.Time `json:"started"`
+	// Duration is the time until the response was written in
+	// milliseconds.
+	Duration     float64 `json:"duration"`
+	RequestSize  int64   `json:"requestSize"`
+	ResponseSize int64   `json:"responseSize"`
+	ContentType  string  `json:"contentType,omitempty"`
+	// Fault is the kind of the fault injected by the network simulator,
+	// see faultHeader. Requests failed without a response, e.g. by a
+	// reset, have status 0.
+	Fault string `json:"fault,omitempty"`
+}
+
+// capturedBody is the beginning of a request or response body.
+type capturedBody struct {
+	Text      string `json:"text"`
+	Encoding  string `json:"encoding,omitempty"` // base64 for binary bodies
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+func newCapturedBody(b []byte, truncated bool) *capturedBody {
+	if utf8.Valid(b) {
+		return &capturedBody{Text: string(b), Truncated: truncated}
+	}
+	return &capturedBody{Text: base64.StdEncoding.EncodeToString(b), Encoding: "base64", Truncated: truncated}
+}
+
+// Bytes returns the captured body.
+func (b *capturedBody) Bytes() []byte {
+	if b.Encoding == "base64" {
+		data, _ := base64.StdEncoding.DecodeString(b.Text)
+		return data
+	}
+	return []byte(b.Text)
+}
+
+// requestLog records the requests passing through the proxy. It keeps the
+// last few requests and publishes every request on the event stream as an
+// eventRequest event. Only the inspector subscribes to these, pages do not.
+//
+//	GET  /_dev/requests               the inspector
+//	GET  /_dev/requests/entries       requestSummary of the recorded requests
+//	GET  /_dev/requests/entries/{id}  a requestEntry
+//	GET  /_dev/requests/har           the recorded requests in HAR format
+//	POST /_dev/requests/clear         forget the recorded requests
+type requestLog struct {
+	stream  *eventStream
+	maxBody int // bytes of the bodies captured, 0 captures none
+
+	mu      sync.Mutex
+	lastID  uint64
+	entries []*requestEntry // ring buffer
+	next    int             // index of the next write in entries
+	full    bool
+}
+
+func newRequestLog(stream *eventStream, size, maxBody int) *requestLog {
+	return &requestLog{
+		stream:  stream,
+		maxBody: maxBody,
+		entries: make([]*requestEntry, max(size, 1)),
+	}
+}
+
+// Wrap records the requests handled by next.
+func (l *requestLog) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isReplay(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		entry := &requestEntry{
+			requestSummary: requestSummary{
+				RequestID: r.Header.Get(requestIDHeader),
+				Method:    r.Method,
+				Host:      r.Host,
+				URL:       r.URL.RequestURI(),
+				Proto:     r.Proto,
+				TLS:       r.TLS != nil,
+				Started:   time.Now(),
+			},
+			RequestHeaders: r.Header.Clone(),
+		}
+
+		var reqBody *captureReader
+		if r.Body != nil && r.Body != http.NoBody {
+			reqBody = &captureReader{ReadCloser: r.Body, max: l.maxBody}
+			r.Body = reqBody
+		}
+		rw := &captureWriter{ResponseWriter: w, max: l.maxBody}
+
+		// Aborted requests, e.g. by a simulated connection reset, are
+		// recorded too.
+		defer func() {
+			entry.Duration = float64(time.Since(entry.Started).Microseconds()) / 1000
+			entry.Fault = w.Header().Get(faultHeader)
+			entry.Status = rw.status
+			if entry.Status == 0 && entry.Fault == "" {
+				entry.Status = http.StatusOK
+			}
+			entry.ResponseHeaders = w.Header().Clone()
+			entry.ContentType = w.Header().Get("content-type")
+			entry.ResponseSize = rw.size
+			if l.maxBody > 0 && rw.size > 0 {
+				entry.ResponseBody = newCapturedBody(rw.buf.Bytes(), rw.size > int64(rw.buf.Len()))
+			}
+			if reqBody != nil {
+				entry.RequestSize = reqBody.size
+				if l.maxBody > 0 && reqBody.size > 0 {
+					entry.RequestBody = newCapturedBody(reqBody.buf.Bytes(), reqBody.size > int64(reqBody.buf.Len()))
+				}
+			}
+
+			l.add(entry)
+		}()
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func (l *requestLog) add(entry *requestEntry) {
+	l.mu.Lock()
+	l.lastID++
+	entry.ID = l.lastID
+	l.entries[l.next] = entry
+	l.next = (l.next + 1) % len(l.entries)
+	l.full = l.full || l.next == 0
+	l.mu.Unlock()
+
+	l.stream.Publish(eventRequest, entry.requestSummary)
+}
+
+// Entries returns the recorded requests, oldest first.
+func (l *requestLog) Entries() []*requestEntry {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.full {
+		return append([]*requestEntry(nil), l.entries[:l.next]...)
+	}
+	return append(append([]*requestEntry(nil), l.entries[l.next:]...), l.entries[:l.next]...)
+}
+
+// Entry returns the recorded request with the given ID.
+func (l *requestLog) Entry(id uint64) (*requestEntry, bool) {
+	for _, e := range l.Entries() {
+		if e.ID == id {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+// Clear forgets the recorded requests.
+func (l *requestLog) Clear() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	clear(l.entries)
+	l.next, l.full = 0, false
+}
+
+func (l *requestLog) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !sameOrigin(r) {
+		http.Error(w, "cross-origin request", http.StatusForbidden)
+		return
+	}
+
+	endpoint := strings.Trim(strings.TrimPrefix(r.URL.Path, requestsPath), "/")
+	method := "GET"
+	if endpoint == "clear" {
+		method = "POST"
+	}
+	if r.Method != method {
+		w.Header().Set("allow", method)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch {
+	case endpoint == "":
+		w.Header().Set("content-type", "text/html; charset=utf-8")
+		w.Header().Set("cache-control", "no-cache")
+		w.Write(requestsPage)
+	case endpoint == "entries":
+		summaries := []requestSummary{}
+		for _, e := range l.Entries() {
+			summaries = append(summaries, e.requestSummary)
+		}
+		writeJSON(w, http.StatusOK, summaries)
+	case strings.HasPrefix(endpoint, "entries/"):
+		id, err := strconv.ParseUint(strings.TrimPrefix(endpoint, "entries/"), 10, 64)
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		entry, ok := l.Entry(id)
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, entry)
+	case endpoint == "har":
+		w.Header().Set("content-disposition", `attachment; filename="devserver.har"`)
+		writeJSON(w, http.StatusOK, newHAR(l.Entries()))
+	case endpoint == "clear":
+		l.Clear()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// captureReader counts the bytes read and keeps the first max bytes.
+type captureReader struct {
+	io.ReadCloser
+	max  int
+	size int64
+	buf  bytes.Buffer
+}
+
+func (r *captureReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.size += int64(n)
+	if room := r.max - r.buf.Len(); room > 0 {
+		r.buf.Write(p[:min(n, room)])
+	}
+	return n, err
+}
+
+// captureWriter records the status, counts the bytes written and keeps the
+// first max bytes.
+type captureWriter struct {
+	http.ResponseWriter
+	max    int
+	status int
+	size   int64
+	buf    bytes.Buffer
+}
+
+func (w *captureWriter) WriteHeader(status int) {
+	// Informational responses, e.g. 103 Early Hints, are followed by the
+	// final status.
+	if w.status == 0 && status >= 200 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	if room := w.max - w.buf.Len(); room > 0 {
+		w.buf.Write(p[:min(n, room)])
+	}
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer, e.g. to
+// flush streamed responses.
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
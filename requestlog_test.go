@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestLog_Wrap(t *testing.T) {
+	stream := newEventStream(10)
+	_, ch, remove := stream.Subscribe(0, eventRequest)
+	defer remove()
+
+	l := newRequestLog(stream, 10, 4)
+	h := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.ReadAll(r.Body)
+		w.Header().Set("content-type", "text/plain")
+		w.WriteHeader(http.StatusCreated)
+		io.WriteString(w, "hello world")
+	}))
+
+	req := httptest.NewRequest("POST", "/items?a=1", strings.NewReader("name=x"))
+	req.Header.Set(requestIDHeader, "req-1")
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	entries := l.Entries()
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	e := entries[0]
+	if e.ID != 1 || e.RequestID != "req-1" || e.Method != "POST" || e.URL != "/items?a=1" || e.Status != http.StatusCreated {
+		t.Errorf("unexpected entry: %+v", e.requestSummary)
+	}
+	if e.RequestSize != 6 || e.ResponseSize != 11 || e.ContentType != "text/plain" {
+		t.Errorf("unexpected sizes: %+v", e.requestSummary)
+	}
+	if e.RequestBody == nil || e.RequestBody.Text != "name" || !e.RequestBody.Truncated {
+		t.Errorf("unexpected request body: %+v", e.RequestBody)
+	}
+	if e.ResponseBody == nil || e.ResponseBody.Text != "hell" || !e.ResponseBody.Truncated {
+		t.Errorf("unexpected response body: %+v", e.ResponseBody)
+	}
+
+	select {
+	case ev := <-ch:
+		if s, ok := ev.Data.(requestSummary); !ok || s.ID != 1 {
+			t.Errorf("unexpected event: %+v", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for event")
+	}
+}
+
+func TestRequestLog_PageSubscription(t *testing.T) {
+	stream := newEventStream(10)
+	first := stream.Change(fsEventBatch{})
+
+	// Subscribed like reload.js, the request events of a page load with
+	// many assets neither reach nor disconnect the page.
+	_, ch, remove := stream.Subscribe(0, eventChange, eventServerError, eventShutdown, eventStatus)
+	defer remove()
+
+	l := newRequestLog(stream, 100, 0)
+	h := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for range 5 * defaultBufferSize {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/asset.css", nil))
+	}
+	stream.Change(fsEventBatch{})
+
+	select {
+	case ev, ok := <-ch:
+		if !ok || ev.Type != eventChange {
+			t.Errorf("expected a change event, got %+v (open: %t)", ev, ok)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for event")
+	}
+
+	if history := stream.History(eventChange); len(history) != 2 || history[0].ID != first.ID {
+		t.Errorf("expected the change events to be retained, got %+v", history)
+	}
+}
+
+func TestRequestLog_Bounded(t *testing.T) {
+	l := newRequestLog(newEventStream(10), 2, 0)
+	h := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	for _, path := range []string{"/a", "/b", "/c"} {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	var urls []string
+	for _, e := range l.Entries() {
+		urls = append(urls, e.URL)
+		if e.ResponseBody != nil {
+			t.Errorf("expected no captured body, got %+v", e.ResponseBody)
+		}
+	}
+	if strings.Join(urls, " ") != "/b /c" {
+		t.Errorf("unexpected entries\nwant: /b /c\ngot:  %s", strings.Join(urls, " "))
+	}
+}
+
+func TestRequestLog_ServeHTTP(t *testing.T) {
+	l := newRequestLog(newEventStream(10), 10, 0)
+	h := l.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte{0xff, 0xfe})
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/a", nil))
+
+	tests := []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{"GET", "/_dev/requests", http.StatusOK},
+		{"GET", "/_dev/requests/entries", http.StatusOK},
+		{"GET", "/_dev/requests/entries/1", http.StatusOK},
+		{"GET", "/_dev/requests/entries/2", http.StatusNotFound},
+		{"GET", "/_dev/requests/clear", http.StatusMethodNotAllowed},
+		{"POST", "/_dev/requests/clear", http.StatusNoContent},
+		{"GET", "/_dev/requests/entries/1", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		l.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("%s %s: unexpected status\nwant: %d\ngot:  %d", tt.method, tt.path, tt.want, rec.Code)
+		}
+		if tt.path == "/_dev/requests/entries" {
+			var got []requestSummary
+			if err := json.NewDecoder(rec.Body).Decode(&got); err != nil || len(got) != 1 || got[0].URL != "/a" {
+				t.Errorf("unexpected entries: %+v (err=%v)", got, err)
+			}
+		}
+	}
+}
+
+func TestCapturedBody(t *testing.T) {
+	for _, b := range [][]byte{[]byte("text"), {0xff, 0x00, 0x01}} {
+		got := newCapturedBody(b, false).Bytes()
+		if string(got) != string(b) {
+			t.Errorf("unexpected body\nwant: %v\ngot:  %v", b, got)
+		}
+	}
+	if enc := newCapturedBody([]byte{0xff}, false).Encoding; enc != "base64" {
+		t.Errorf("expected base64 encoding for binary bodies, got %q", enc)
+	}
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// eventServerError events carry a serverError.
+const eventServerError = "server-error"
+
+// requestIDHeader is set on proxied requests that do not have a request ID
+// yet. Servers logging the ID let devserver tell which request failed.
+const requestIDHeader = "X-Request-Id"
+
+// serverError is a panic or an error logged by the server.
+type serverError struct {
+	Kind    string       `json:"kind"`    // panic or error
+	Message string       `json:"message"` // first line
+	Text    string       `json:"text"`    // every line, e.g. the stack trace
+	Request *requestInfo `json:"request,omitempty"`
+	Time    time.Time    `json:"time"`
+}
+
+// defaultErrorPatterns match error logs of the common log formats, e.g.
+// log/slog's JSON and text handlers.
+var defaultErrorPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?i)"level":\s*"(error|fatal)"`),
+	regexp.MustCompile(`(?i)\blevel=(error|fatal)\b`),
+}
+
+// errorPatternFlag collects the values of the repeatable -error-pattern flag.
+type errorPatternFlag []*regexp.Regexp
+
+func (f *errorPatternFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	var s []string
+	for _, re := range *f {
+		s = append(s, re.String())
+	}
+	return strings.Join(s, ", ")
+}
+
+func (f *errorPatternFlag) Set(v string) error {
+	re, err := regexp.Compile(v)
+	if err != nil {
+		return fmt.Errorf("invalid error pattern: %w", err)
+	}
+	*f = append(*f, re)
+	return nil
+}
+
+// Start of a panic: an unrecovered panic or one recovered by net/http.
+var panicRe = regexp.MustCompile(`(^|\s)panic: |http: panic serving `)
+
+const (
+	// traceWait is how long the scanner waits for more lines of a stack
+	// trace.
+	traceWait = 100 * time.Millisecond
+	// maxTraceLines limits the length of a reported stack trace.
+	maxTraceLines = 200
+)
+
+// errorScanner scans the output of the server for panics and lines matching
+// the error patterns and reports them.
+type errorScanner struct {
+	patterns []*regexp.Regexp
+	requests *requestTracker
+	report   func(serverError)
+	wait     time.Duration
+
+	mu    sync.Mutex
+	trace *serverError // panic being collected
+	lines int
+	timer *time.Timer
+}
+
+func newErrorScanner(patterns []*regexp.Regexp, requests *requestTracker, report func(serverError)) *errorScanner {
+	if len(patterns) == 0 {
+		patterns = defaultErrorPatterns
+	}
+	return &errorScanner{
+		patterns: patterns,
+		requests: requests,
+		report:   report,
+		wait:     traceWait,
+	}
+}
+
+// Line scans a line of output. Lines of sources other than the server are
+// ignored.
+func (s *errorScanner) Line(e logEntry) {
+	if e.Source != sourceServer {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.trace != nil {
+		if isTraceLine(e.Text) {
+			if s.lines < maxTraceLines {
+				s.trace.Text += "\n" + e.Text
+				s.lines++
+			}
+			s.timer.Reset(s.wait)
+			return
+		}
+		s.flush()
+	}
+
+	switch {
+	case panicRe.MatchString(e.Text):
+		trace := &serverError{Kind: "panic", Message: e.Text, Text: e.Text, Time: e.Time}
+		s.trace, s.lines = trace, 1
+		s.timer = time.AfterFunc(s.wait, func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if s.trace == trace {
+				s.flush()
+			}
+		})
+	case s.matches(e.Text):
+		s.reportError(serverError{Kind: "error", Message: e.Text, Text: e.Text, Time: e.Time})
+	}
+}
+
+func (s *errorScanner) matches(line string) bool {
+	for _, re := range s.patterns {
+		if re.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// flush reports the panic being collected. s.mu must be held.
+func (s *errorScanner) flush() {
+	if s.trace == nil {
+		return
+	}
+	s.timer.Stop()
+	s.trace.Text = strings.TrimRight(s.trace.Text, "\n")
+	s.reportError(*s.trace)
+	s.trace, s.timer = nil, nil
+}
+
+func (s *errorScanner) reportError(e serverError) {
+	if s.requests != nil {
+		e.Request = s.requests.Correlate(e.Text)
+	}
+	s.report(e)
+}
+
+// isTraceLine reports whether line can be part of a goroutine stack trace.
+func isTraceLine(line string) bool {
+	switch {
+	case line == "",
+		strings.HasPrefix(line, "\t"),
+		strings.HasPrefix(line, "goroutine "),
+		strings.HasPrefix(line, "created by "),
+		strings.HasPrefix(line, "panic: "),
+		strings.HasPrefix(line, "[signal "),
+		strings.HasPrefix(line, "..."),
+		strings.HasPrefix(line, "exit status "):
+		return true
+	}
+	// Function calls, e.g. main.(*handler).ServeHTTP(0xc000010000, ...)
+	return !strings.ContainsAny(line[:1], " \t") && strings.Contains(line, "(") && strings.HasSuffix(line, ")")
+}
+
+// requestInfo identifies a proxied request.
+type requestInfo struct {
+	ID     string `json:"id"`
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// recentRequestAge is how long finished requests are considered when
+// correlating errors. Output of the server arrives with a small delay.
+const recentRequestAge = 1 * time.Second
+
+// requestTracker keeps track of the requests in flight so that errors can be
+// correlated with the request that caused them.
+type requestTracker struct {
+	mu       sync.Mutex
+	inflight map[string]requestInfo
+	recent   []finishedRequest
+}
+
+type finishedRequest struct {
+	requestInfo
+	at time.Time
+}
+
+func newRequestTracker() *requestTracker {
+	return &requestTracker{inflight: make(map[string]requestInfo)}
+}
+
+// Wrap tracks the requests handled by next. Requests without a request ID
+// get one.
+func (t *requestTracker) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if id == "" {
+			id = fmt.Sprintf("%016x", rand.Uint64())
+			r = r.Clone(r.Context())
+			r.Header.Set(requestIDHeader, id)
+		}
+
+		info := requestInfo{ID: id, Method: r.Method, URL: r.URL.RequestURI()}
+		t.mu.Lock()
+		t.inflight[id] = info
+		t.mu.Unlock()
+
+		defer func() {
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			delete(t.inflight, id)
+			t.recent = append(t.prune(), finishedRequest{requestInfo: info, at: time.Now()})
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// prune returns the recent requests that are not too old. t.mu must be held.
+func (t *requestTracker) prune() []finishedRequest {
+	i := 0
+	for i < len(t.recent) && time.Since(t.recent[i].at) > recentRequestAge {
+		i++
+	}
+	return t.recent[i:]
+}
+
+// Correlate returns the request that text is about: the request whose ID is
+// part of text or, if there is only one, the request in flight or finished
+// recently. It returns nil if the request cannot be determined.
+func (t *requestTracker) Correlate(text string) *requestInfo {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.recent = t.prune()
+	candidates := make([]requestInfo, 0, len(t.inflight)+len(t.recent))
+	for _, info := range t.inflight {
+		candidates = append(candidates, info)
+	}
+	for _, r := range t.recent {
+		candidates = append(candidates, r.requestInfo)
+	}
+
+	for _, info := range candidates {
+		if strings.Contains(text, info.ID) {
+			return &info
+		}
+	}
+	if len(candidates) == 1 {
+		return &candidates[0]
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestErrorScanner(t *testing.T) {
+	const panicTrace = `2025/01/02 15:04:05 http: panic serving 127.0.0.1:50000: boom
+goroutine 7 [running]:
+net/http.(*conn).serve.func1()
+	/usr/local/go/src/net/http/server.go:1947 +0xbe
+panic({0x6d2f40?, 0x7f5a10?})
+	/usr/local/go/src/runtime/panic.go:785 +0x132
+main.handler({0x7f9c98, 0xc0001c6000}, 0xc0001b4140)
+	/app/main.go:12 +0x25
+created by net/http.(*Server).Serve in goroutine 1
+	/usr/local/go/src/net/http/server.go:3360 +0x485`
+
+	tests := []struct {
+		name  string
+		lines string
+		want  []serverError
+	}{
+		{
+			name:  "Recovered panic",
+			lines: panicTrace + "\n2025/01/02 15:04:06 next request",
+			want: []serverError{{
+				Kind:    "panic",
+				Message: "2025/01/02 15:04:05 http: panic serving 127.0.0.1:50000: boom",
+				Text:    panicTrace,
+			}},
+		},
+		{
+			name:  "Unrecovered panic",
+			lines: "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:5 +0x18\nexit status 2",
+			want: []serverError{{
+				Kind:    "panic",
+				Message: "panic: boom",
+				Text:    "panic: boom\n\ngoroutine 1 [running]:\nmain.main()\n\t/app/main.go:5 +0x18\nexit status 2",
+			}},
+		},
+		{
+			name: "Error logs",
+			lines: `{"time":"2025-01-02T15:04:05Z","level":"ERROR","msg":"query failed"}
+{"time":"2025-01-02T15:04:05Z","level":"INFO","msg":"request"}
+time=2025-01-02T15:04:05Z level=ERROR msg="query failed"`,
+			want: []serverError{
+				{
+					Kind:    "error",
+					Message: `{"time":"2025-01-02T15:04:05Z","level":"ERROR","msg":"query failed"}`,
+					Text:    `{"time":"2025-01-02T15:04:05Z","level":"ERROR","msg":"query failed"}`,
+				},
+				{
+					Kind:    "error",
+					Message: `time=2025-01-02T15:04:05Z level=ERROR msg="query failed"`,
+					Text:    `time=2025-01-02T15:04:05Z level=ERROR msg="query failed"`,
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reported := make(chan serverError, 10)
+			s := newErrorScanner(nil, nil, func(e serverError) { reported <- e })
+			s.wait = 10 * time.Millisecond
+
+			for line := range strings.SplitSeq(tt.lines, "\n") {
+				s.Line(logEntry{Source: sourceServer, Text: line})
+			}
+			// Output of other sources is ignored.
+			s.Line(logEntry{Source: sourceBuild, Text: "panic: not the server"})
+
+			for _, want := range tt.want {
+				select {
+				case got := <-reported:
+					got.Time = time.Time{}
+					if got.Kind != want.Kind || got.Message != want.Message || got.Text != want.Text {
+						t.Errorf("unexpected error\nwant: %+v\ngot:  %+v", want, got)
+					}
+				case <-time.After(time.Second):
+					t.Fatal("Timeout waiting for error")
+				}
+			}
+			select {
+			case got := <-reported:
+				t.Errorf("unexpected error: %+v", got)
+			case <-time.After(50 * time.Millisecond):
+			}
+		})
+	}
+}
+
+func TestRequestTracker_Correlate(t *testing.T) {
+	tr := newRequestTracker()
+
+	release := make(chan struct{})
+	started := make(chan string, 2)
+	h := tr.Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- r.Header.Get(requestIDHeader)
+		<-release
+	}))
+
+	serve := func(path, id string) {
+		req := httptest.NewRequest("GET", path, nil)
+		if id != "" {
+			req.Header.Set(requestIDHeader, id)
+		}
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	go serve("/a", "")
+	generated := <-started
+	if generated == "" {
+		t.Fatal("expected a generated request ID")
+	}
+
+	if got := tr.Correlate("boom"); got == nil || got.URL != "/a" {
+		t.Errorf("expected the only request in flight, got %+v", got)
+	}
+
+	go serve("/b", "req-b")
+	<-started
+
+	if got := tr.Correlate("boom"); got != nil {
+		t.Errorf("expected no request with two requests in flight, got %+v", got)
+	}
+	if got := tr.Correlate(`level=ERROR msg=boom request_id=req-b`); got == nil || got.URL != "/b" {
+		t.Errorf("expected the request with the logged ID, got %+v", got)
+	}
+
+	close(release)
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+var (
+	levelColors = map[slog.Level]*color.Color{
+		slog.LevelDebug: color.New(color.FgBlue),
+		slog.LevelInfo:  color.New(color.FgGreen),
+		slog.LevelWarn:  color.New(color.FgYellow),
+		slog.LevelError: color.New(color.FgRed, color.Bold),
+	}
+	keyColor = color.New(color.Faint)
+)
+
+// logFilterFlag collects the values of the repeatable -log-filter flag.
+type logFilterFlag []logFilter
+
+// logFilter matches JSON log lines with attribute Key equal to Value. Keys of
+// nested groups are joined with dots, e.g. request.method.
+type logFilter struct {
+	Key, Value string
+}
+
+func (f *logFilterFlag) String() string {
+	if f == nil {
+		return ""
+	}
+	var s []string
+	for _, lf := range *f {
+		s = append(s, lf.Key+"="+lf.Value)
+	}
+	return strings.Join(s, ", ")
+}
+
+func (f *logFilterFlag) Set(v string) error {
+	key, value, ok := strings.Cut(v, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("invalid log filter %q, expected key=value", v)
+	}
+	*f = append(*f, logFilter{Key: key, Value: value})
+	return nil
+}
+
+// slogView pretty-prints the JSON log lines written by log/slog's
+// JSONHandler and filters them by level and attributes. Other lines are left
+// unchanged.
+type slogView struct {
+	pretty   bool       // pretty-print JSON lines
+	minLevel slog.Level // hide JSON lines below this level
+	filters  []logFilter
+}
+
+// Format formats the text of e for the terminal. It returns false if e must
+// not be shown. Only the output of the server is formatted.
+func (v *slogView) Format(e logEntry) (string, bool) {
+	if e.Source != sourceServer {
+		return e.Text, true
+	}
+	attrs, ok := parseJSONLog(e.Text)
+	if !ok {
+		return e.Text, true
+	}
+
+	rec := newLogRecord(attrs)
+	if rec.hasLevel && rec.Level < v.minLevel {
+		return "", false
+	}
+	for _, f := range v.filters {
+		if !rec.Has(f.Key, f.Value) {
+			return "", false
+		}
+	}
+
+	if !v.pretty {
+		return e.Text, true
+	}
+	return rec.String(), true
+}
+
+// logAttr is an attribute of a JSON log line. Value is a string,
+// json.Number, bool, nil or, for arrays, json.RawMessage.
+type logAttr struct {
+	Key   string
+	Value any
+}
+
+// parseJSONLog parses a JSON object. The attributes are returned in order,
+// nested objects are flattened.
+func parseJSONLog(line string) ([]logAttr, bool) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "{") || !strings.HasSuffix(line, "}") {
+		return nil, false
+	}
+	attrs, err := parseJSONObject([]byte(line), "")
+	if err != nil {
+		return nil, false
+	}
+	return attrs, true
+}
+
+func parseJSONObject(data []byte, prefix string) ([]logAttr, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if t, err := dec.Token(); err != nil || t != json.Delim('{') {
+		return nil, fmt.Errorf("not a JSON object")
+	}
+
+	var attrs []logAttr
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return nil, err
+		}
+		key := prefix + t.(string)
+
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, err
+		}
+		switch raw[0] {
+		case '{':
+			group, err := parseJSONObject(raw, key+".")
+			if err != nil {
+				return nil, err
+			}
+			attrs = append(attrs, group...)
+		case '[':
+			var b bytes.Buffer
+			json.Compact(&b, raw)
+			attrs = append(attrs, logAttr{Key: key, Value: json.RawMessage(b.Bytes())})
+		default:
+			d := json.NewDecoder(bytes.NewReader(raw))
+			d.UseNumber()
+			var value any
+			if err := d.Decode(&value); err != nil {
+				return nil, err
+			}
+			attrs = append(attrs, logAttr{Key: key, Value: value})
+		}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, err
+	}
+	return attrs, nil
+}
+
+// logRecord is a JSON log line split into the built-in slog attributes and
+// the rest.
+type logRecord struct {
+	Time   string // formatted time, as logged if it cannot be parsed
+	Level  slog.Level
+	Msg    string
+	Source string // file:line
+	Attrs  []logAttr
+
+	hasTime, hasLevel bool
+}
+
+func newLogRecord(attrs []logAttr) logRecord {
+	var (
+		rec            logRecord
+		file, line, fn string
+		hasMsg         bool
+	)
+	for _, a := range attrs {
+		switch {
+		case a.Key == slog.TimeKey && !rec.hasTime:
+			s := formatValue(a.Value)
+			if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+				s = t.Format("15:04:05.000")
+			}
+			rec.Time, rec.hasTime = s, true
+		case a.Key == slog.LevelKey && !rec.hasLevel:
+			// Custom levels are logged as e.g. ERROR+2 which
+			// UnmarshalText understands.
+			if err := rec.Level.UnmarshalText([]byte(formatValue(a.Value))); err == nil {
+				rec.hasLevel = true
+				continue
+			}
+			rec.Attrs = append(rec.Attrs, a)
+		case a.Key == slog.MessageKey && !hasMsg:
+			rec.Msg, hasMsg = formatValue(a.Value), true
+		case a.Key == slog.SourceKey+".file":
+			file = formatValue(a.Value)
+		case a.Key == slog.SourceKey+".line":
+			line = formatValue(a.Value)
+		case a.Key == slog.SourceKey+".function":
+			fn = formatValue(a.Value)
+		default:
+			rec.Attrs = append(rec.Attrs, a)
+		}
+	}
+	switch {
+	case file != "" && line != "":
+		rec.Source = file + ":" + line
+	case file != "":
+		rec.Source = file
+	default:
+		rec.Source = fn
+	}
+	return rec
+}
+
+// Has reports whether the record has the attribute key with value. The
+// level and the message can be matched too.
+func (r logRecord) Has(key, value string) bool {
+	switch key {
+	case slog.LevelKey:
+		return r.hasLevel && strings.EqualFold(r.Level.String(), value)
+	case slog.MessageKey:
+		return r.Msg == value
+	}
+	for _, a := range r.Attrs {
+		if a.Key == key && formatValue(a.Value) == value {
+			return true
+		}
+	}
+	return false
+}
+
+// String formats the record as "time LEVEL message key=value ...".
+func (r logRecord) String() string {
+	var b strings.Builder
+	if r.hasTime {
+		b.WriteString(r.Time)
+		b.WriteByte(' ')
+	}
+	if r.hasLevel {
+		b.WriteString(levelColor(r.Level).Sprintf("%-5s", r.Level))
+		b.WriteByte(' ')
+	}
+	b.WriteString(r.Msg)
+	for _, a := range r.Attrs {
+		b.WriteByte(' ')
+		b.WriteString(keyColor.Sprint(a.Key + "="))
+		if raw, ok := a.Value.(json.RawMessage); ok {
+			b.Write(raw)
+		} else {
+			b.WriteString(quoteValue(formatValue(a.Value)))
+		}
+	}
+	if r.Source != "" {
+		b.WriteByte(' ')
+		b.WriteString(keyColor.Sprint(slog.SourceKey + "=" + r.Source))
+	}
+	return b.String()
+}
+
+// levelColor returns the color of the closest built-in level below l.
+func levelColor(l slog.Level) *color.Color {
+	switch {
+	case l >= slog.LevelError:
+		return levelColors[slog.LevelError]
+	case l >= slog.LevelWarn:
+		return levelColors[slog.LevelWarn]
+	case l >= slog.LevelInfo:
+		return levelColors[slog.LevelInfo]
+	}
+	return levelColors[slog.LevelDebug]
+}
+
+func formatValue(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case json.RawMessage:
+		return string(v)
+	case nil:
+		return "null"
+	}
+	return fmt.Sprint(v)
+}
+
+// quoteValue quotes s like log/slog's TextHandler does.
+func quoteValue(s string) string {
+	if s == "" || strings.ContainsAny(s, " =\"\t\n\r") || !strconv.CanBackquote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"log/slog"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+func TestSlogView_Format(t *testing.T) {
+	color.NoColor = true
+
+	tests := []struct {
+		name  string
+		view  slogView
+		line  string
+		want  string
+		shown bool
+	}{
+		{
+			name:  "Pretty",
+			view:  slogView{pretty: true, minLevel: slog.LevelDebug},
+			line:  `{"time":"2025-01-02T15:04:05.123456789Z","level":"INFO","msg":"request","method":"GET","path":"/a b","status":200}`,
+			want:  `15:04:05.123 INFO  request method=GET path="/a b" status=200`,
+			shown: true,
+		},
+		{
+			name:  "Groups and source",
+			view:  slogView{pretty: true},
+			line:  `{"level":"ERROR+2","msg":"failed","source":{"function":"main.f","file":"/app/main.go","line":12},"req":{"id":"x1","tags":["a", "b"]},"err":null}`,
+			want:  `ERROR+2 failed req.id=x1 req.tags=["a","b"] err=null source=/app/main.go:12`,
+			shown: true,
+		},
+		{
+			name:  "Not JSON",
+			view:  slogView{pretty: true, minLevel: slog.LevelError},
+			line:  `listening on :8080 {}`,
+			want:  `listening on :8080 {}`,
+			shown: true,
+		},
+		{
+			name:  "Invalid JSON",
+			view:  slogView{pretty: true},
+			line:  `{"msg": }`,
+			want:  `{"msg": }`,
+			shown: true,
+		},
+		{
+			name:  "Not pretty",
+			view:  slogView{},
+			line:  `{"level":"INFO","msg":"request"}`,
+			want:  `{"level":"INFO","msg":"request"}`,
+			shown: true,
+		},
+		{
+			name:  "Below level",
+			view:  slogView{pretty: true, minLevel: slog.LevelWarn},
+			line:  `{"level":"INFO","msg":"request"}`,
+			shown: false,
+		},
+		{
+			name:  "Filter matches",
+			view:  slogView{filters: []logFilter{{"req.method", "POST"}, {"level", "info"}}},
+			line:  `{"level":"INFO","msg":"request","req":{"method":"POST"}}`,
+			want:  `{"level":"INFO","msg":"request","req":{"method":"POST"}}`,
+			shown: true,
+		},
+		{
+			name:  "Filter does not match",
+			view:  slogView{filters: []logFilter{{"req.method", "POST"}}},
+			line:  `{"level":"INFO","msg":"request","req":{"method":"GET"}}`,
+			shown: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, shown := tt.view.Format(logEntry{Source: sourceServer, Text: tt.line})
+			if shown != tt.shown {
+				t.Fatalf("unexpected shown\nwant: %t\ngot:  %t", tt.shown, shown)
+			}
+			if shown && got != tt.want {
+				t.Errorf("unexpected line\nwant: %s\ngot:  %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSlogView_OtherSources(t *testing.T) {
+	v := slogView{pretty: true, minLevel: slog.LevelError}
+	line := `{"level":"INFO","msg":"build"}`
+	if got, shown := v.Format(logEntry{Source: sourceBuild, Text: line}); !shown || got != line {
+		t.Errorf("expected the line unchanged, got %q (shown=%t)", got, shown)
+	}
+}
+
+func TestLogFilterFlag(t *testing.T) {
+	var f logFilterFlag
+	if err := f.Set("req.method=POST"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := f.Set("nope"); err == nil {
+		t.Error("expected an error for a filter without =")
+	}
+	if len(f) != 1 || f[0] != (logFilter{"req.method", "POST"}) {
+		t.Errorf("unexpected filters: %v", f)
+	}
+}
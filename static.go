@@ -0,0 +1,144 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// staticHeader is set on responses served from the web root. Its value is
+// the path of the file relative to the web root.
+const staticHeader = "X-Devserver-Static"
+
+// staticTransport serves the files of the web root for URL paths starting
+// with prefix instead of requesting them from the server, so changes to
+// assets are visible even if the server embeds them at build time or is
+// restarting. A URL path is mapped to the file with the same path relative
+// to the web root, like the URLs reported in reload events. Requests for
+// missing files and requests other than GET and HEAD are passed to next.
+//
+// The responses go through the reverse proxy like the responses of the
+// server, so JavaScript modules are rewritten for hot module replacement and
+// HTML pages get the reload script.
+type staticTransport struct {
+	root   fs.FS
+	prefix string
+	files  http.Handler
+	next   http.RoundTripper
+}
+
+func newStaticTransport(root fs.FS, prefix string, next http.RoundTripper) *staticTransport {
+	return &staticTransport{
+		root:   root,
+		prefix: prefix,
+		files:  http.FileServerFS(root),
+		next:   next,
+	}
+}
+
+func (t *staticTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead || !strings.HasPrefix(req.URL.Path, t.prefix) {
+		return t.next.RoundTrip(req)
+	}
+	name := strings.TrimPrefix(path.Clean(req.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	fi, err := fs.Stat(t.root, name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return t.next.RoundTrip(req)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("static: %w", err)
+	}
+
+	w := newPipeResponseWriter(req)
+	if fi.IsDir() && strings.HasSuffix(req.URL.Path, "/") {
+		if index, err := fs.Stat(t.root, path.Join(name, "index.html")); err == nil {
+			fi = index
+		}
+	}
+	if !fi.IsDir() {
+		w.Header().Set("etag", fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()))
+	}
+	w.Header().Set("cache-control", "no-cache")
+	w.Header().Set(staticHeader, name)
+	go w.serve(t.files)
+	return w.response()
+}
+
+// pipeResponseWriter turns the response of a handler into an http.Response
+// whose body is streamed from the handler.
+type pipeResponseWriter struct {
+	req    *http.Request
+	header http.Header
+	pr     *io.PipeReader
+	pw     *io.PipeWriter
+	resp   chan *http.Response
+	sent   bool
+}
+
+func newPipeResponseWriter(req *http.Request) *pipeResponseWriter {
+	pr, pw := io.Pipe()
+	return &pipeResponseWriter{
+		req:    req,
+		header: http.Header{},
+		pr:     pr,
+		pw:     pw,
+		resp:   make(chan *http.Response, 1),
+	}
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(code int) {
+	if w.sent {
+		return
+	}
+	w.sent = true
+	length := int64(-1)
+	if n, err := strconv.ParseInt(w.header.Get("content-length"), 10, 64); err == nil {
+		length = n
+	}
+	w.resp <- &http.Response{
+		Status:        fmt.Sprintf("%d %s", code, http.StatusText(code)),
+		StatusCode:    code,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header.Clone(),
+		Body:          w.pr,
+		ContentLength: length,
+		Request:       w.req,
+	}
+}
+
+func (w *pipeResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.pw.Write(p)
+}
+
+// serve runs h and closes the body when it returns.
+func (w *pipeResponseWriter) serve(h http.Handler) {
+	defer w.pw.Close()
+	h.ServeHTTP(w, w.req)
+	w.WriteHeader(http.StatusOK)
+}
+
+// response waits for the handler to write the header.
+func (w *pipeResponseWriter) response() (*http.Response, error) {
+	select {
+	case resp := <-w.resp:
+		return resp, nil
+	case <-w.req.Context().Done():
+		w.pr.CloseWithError(w.req.Context().Err())
+		return nil, w.req.Context().Err()
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestStaticTransport(t *testing.T) {
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	root := fstest.MapFS{
+		"static/app.css":    {Data: []byte("body {}"), ModTime: modTime},
+		"static/index.html": {Data: []byte("<p>index</p>"), ModTime: modTime},
+		"static/img/a.svg":  {Data: []byte("<svg/>"), ModTime: modTime},
+		"other.css":         {Data: []byte("other"), ModTime: modTime},
+	}
+	upstream := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		rec := httptest.NewRecorder()
+		rec.WriteString("upstream")
+		return rec.Result(), nil
+	})
+	transport := newStaticTransport(root, "/static/", upstream)
+
+	tests := []struct {
+		method, path string
+		header       http.Header
+		status       int
+		contentType  string
+		body         string
+	}{
+		{"GET", "/static/app.css", nil, 200, "text/css; charset=utf-8", "body {}"},
+		{"HEAD", "/static/app.css", nil, 200, "text/css; charset=utf-8", ""},
+		{"GET", "/static/app.css", http.Header{"If-None-Match": {`"17a668b730013200-7"`}}, 304, "", ""},
+		{"GET", "/static/", nil, 200, "text/html; charset=utf-8", "<p>index</p>"},
+		{"GET", "/static/img", nil, 301, "", ""},
+		{"GET", "/static/img/", nil, 200, "text/html; charset=utf-8", "<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n<a href=\"a.svg\">a.svg</a>\n</pre>\n"},
+		{"GET", "/static/missing.css", nil, 200, "", "upstream"},
+		{"POST", "/static/app.css", nil, 200, "", "upstream"},
+		{"GET", "/other.css", nil, 200, "", "upstream"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		for name, values := range tt.header {
+			req.Header[name] = values
+		}
+		resp, err := transport.RoundTrip(req)
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %v", tt.method, tt.path, err)
+			continue
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tt.status || string(body) != tt.body {
+			t.Errorf("%s %s\nwant: %d %q\ngot:  %d %q", tt.method, tt.path, tt.status, tt.body, resp.StatusCode, body)
+		}
+		if ct := resp.Header.Get("content-type"); tt.contentType != "" && ct != tt.contentType {
+			t.Errorf("%s %s: unexpected content type %q", tt.method, tt.path, ct)
+		}
+	}
+
+	resp, _ := transport.RoundTrip(httptest.NewRequest("GET", "/static/app.css", nil))
+	resp.Body.Close()
+	if got, want := resp.Header.Get("etag"), `"17a668b730013200-7"`; got != want {
+		t.Errorf("want ETag %s, got %s", want, got)
+	}
+	if got := resp.Header.Get("cache-control"); got != "no-cache" {
+		t.Errorf("want Cache-Control no-cache, got %q", got)
+	}
+	if got := resp.Header.Get("last-modified"); got != modTime.Format(http.TimeFormat) {
+		t.Errorf("unexpected Last-Modified %q", got)
+	}
+	if got := resp.Header.Get(staticHeader); got != "static/app.css" {
+		t.Errorf("unexpected %s %q", staticHeader, got)
+	}
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Server states reported by serverStatus.
+const (
+	stateIdle       = "idle"
+	stateBuilding   = "building"
+	stateRestarting = "restarting"
+	stateError      = "error"
+	stateStopping   = "stopping"
+)
+
+// eventStatus events carry a statusSnapshot.
+const eventStatus = "status"
+
+// statusSnapshot is the state of devserver at a point in time.
+type statusSnapshot struct {
+	State      string    `json:"state"`
+	Error      string    `json:"error,omitempty"`
+	LiveReload bool      `json:"liveReload"`
+	Paused     bool      `json:"paused"` // file watching is paused
+	Since      time.Time `json:"since"`  // time of the last state change
+
+	// LastBuild is the duration of the last build in milliseconds.
+	LastBuild   int64     `json:"lastBuild"`
+	LastBuildOK bool      `json:"lastBuildOK"`
+	LastBuildAt time.Time `json:"lastBuildAt,omitzero"`
+
+	// PID of the server process, 0 if the server is not running.
+	PID             int       `json:"pid"`
+	ServerStartedAt time.Time `json:"serverStartedAt,omitzero"`
+	// ServerUptime is the time since the server was started in
+	// milliseconds.
+	ServerUptime int64 `json:"serverUptime"`
+
+	StartedAt time.Time `json:"startedAt"`
+	// Uptime is the time since devserver was started in milliseconds.
+	Uptime int64 `json:"uptime"`
+
+	// Clients is the number of browsers connected to the event stream.
+	Clients int `json:"clients"`
+}
+
+// serverStatus tracks the state of the build and the server process. Every
+// change is published on the event stream.
+type serverStatus struct {
+	stream *eventStream
+
+	mu    sync.Mutex
+	state statusSnapshot
+}
+
+func newServerStatus(stream *eventStream, liveReload bool) *serverStatus {
+	return &serverStatus{
+		stream: stream,
+		state: statusSnapshot{
+			State:      stateIdle,
+			LiveReload: liveReload,
+			Since:      time.Now(),
+			StartedAt:  time.Now(),
+		},
+	}
+}
+
+// Snapshot returns the current state.
+func (s *serverStatus) Snapshot() statusSnapshot {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot()
+}
+
+// snapshot fills in the computed fields of the state. s.mu must be held.
+func (s *serverStatus) snapshot() statusSnapshot {
+	st := s.state
+	st.Uptime = time.Since(st.StartedAt).Milliseconds()
+	if st.PID != 0 {
+		st.ServerUptime = time.Since(st.ServerStartedAt).Milliseconds()
+	}
+	st.Clients = s.stream.Listeners()
+	return st
+}
+
+// LiveReload reports whether live reload is enabled.
+func (s *serverStatus) LiveReload() bool {
+	return s.Snapshot().LiveReload
+}
+
+// SetState changes the state. errMsg is only kept in the error state.
+func (s *serverStatus) SetState(state string, errMsg string) {
+	s.update(func(st *statusSnapshot) {
+		st.State = state
+		st.Error = ""
+		if state == stateError {
+			st.Error = errMsg
+		}
+		st.Since = time.Now()
+	})
+}
+
+// BuildDone records the result of a build.
+func (s *serverStatus) BuildDone(d time.Duration, ok bool) {
+	s.update(func(st *statusSnapshot) {
+		st.LastBuild = d.Milliseconds()
+		st.LastBuildOK = ok
+		st.LastBuildAt = time.Now()
+	})
+}
+
+// ServerStarted records that the server process was started.
+func (s *serverStatus) ServerStarted(pid int) {
+	s.update(func(st *statusSnapshot) {
+		st.PID = pid
+		st.ServerStartedAt = time.Now()
+	})
+}
+
+// ServerStopped records that the server process with pid exited. Exiting
+// while idle, i.e. not because of a restart, is an error.
+func (s *serverStatus) ServerStopped(pid int) {
+	s.mu.Lock()
+	if s.state.PID != pid {
+		// A new server was started in the meantime.
+		s.mu.Unlock()
+		return
+	}
+	s.state.PID = 0
+	s.state.ServerStartedAt = time.Time{}
+	if s.state.State == stateIdle {
+		s.state.State = stateError
+		s.state.Error = "server exited"
+		s.state.Since = time.Now()
+	}
+	snapshot := s.snapshot()
+	s.mu.Unlock()
+
+	s.stream.Publish(eventStatus, snapshot)
+}
+
+// Paused reports whether file watching is paused.
+func (s *serverStatus) Paused() bool {
+	return s.Snapshot().Paused
+}
+
+// SetPaused pauses or resumes file watching.
+func (s *serverStatus) SetPaused(paused bool) {
+	s.update(func(st *statusSnapshot) {
+		st.Paused = paused
+	})
+}
+
+// SetLiveReload enables or disables live reload.
+func (s *serverStatus) SetLiveReload(enabled bool) {
+	s.update(func(st *statusSnapshot) {
+		st.LiveReload = enabled
+	})
+}
+
+func (s *serverStatus) update(f func(*statusSnapshot)) {
+	s.mu.Lock()
+	f(&s.state)
+	snapshot := s.snapshot()
+	s.mu.Unlock()
+
+	s.stream.Publish(eventStatus, snapshot)
+}
@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || dragonfly || freebsd || netbsd || openbsd)
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// cbreak is not supported on this platform, keyboard shortcuts are read line
+// by line.
+func cbreak(f *os.File) (func(), error) {
+	return nil, errors.New("cbreak mode is not supported on this platform")
+}
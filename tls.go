@@ -0,0 +1,284 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Files in the certificate directory.
+const (
+	caCertFile   = "ca.pem"
+	caKeyFile    = "ca-key.pem"
+	leafCertFile = "cert.pem"
+	leafKeyFile  = "key.pem"
+)
+
+const (
+	caValidity   = 10 * 365 * 24 * time.Hour
+	leafValidity = 825 * 24 * time.Hour // the maximum accepted by macOS
+	// leafRenewal is how long before expiry the leaf certificate is
+	// replaced.
+	leafRenewal = 30 * 24 * time.Hour
+)
+
+// certDir returns the directory the local CA and the certificates are kept
+// in.
+func certDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "devserver"), nil
+}
+
+// localCA is the certificate authority issuing the certificates of the TLS
+// listener. It is created once and has to be trusted by the browser.
+type localCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  crypto.Signer
+}
+
+// loadCA loads the CA from dir. It is created if neither its certificate nor
+// its key exist, created reports whether it was. A CA missing one of them is
+// an error, the certificate may be trusted already and must not be replaced.
+func loadCA(dir string) (ca *localCA, created bool, err error) {
+	certPath, keyPath := filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile)
+	ca = &localCA{dir: dir}
+	ca.cert, ca.key, err = loadKeyPair(certPath, keyPath)
+	if err == nil {
+		return ca, false, nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, fmt.Errorf("tls: %w", err)
+	}
+	for _, p := range []string{certPath, keyPath} {
+		if _, err := os.Stat(p); !errors.Is(err, fs.ErrNotExist) {
+			return nil, false, fmt.Errorf("tls: incomplete local CA in %s, remove %s and %s to create a new one", dir, caCertFile, caKeyFile)
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, false, fmt.Errorf("tls: %w", err)
+	}
+	host, _ := os.Hostname()
+	tmpl := &x509.Certificate{
+		SerialNumber:          randomSerial(),
+		Subject:               pkix.Name{Organization: []string{"devserver"}, CommonName: "devserver local CA " + host},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(caValidity),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		MaxPathLenZero:        true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, key.Public(), key)
+	if err != nil {
+		return nil, false, fmt.Errorf("tls: %w", err)
+	}
+	if err := writeKeyPair(dir, caCertFile, caKeyFile, der, key); err != nil {
+		return nil, false, err
+	}
+	ca.cert, _ = x509.ParseCertificate(der)
+	ca.key = key
+	return ca, true, nil
+}
+
+// CertPath returns the path of the CA certificate.
+func (ca *localCA) CertPath() string {
+	return filepath.Join(ca.dir, caCertFile)
+}
+
+// Certificate returns a certificate for hosts, host names or IP addresses.
+// The certificate issued last is reused if it covers hosts and does not
+// expire soon.
+func (ca *localCA) Certificate(hosts []string) (tls.Certificate, error) {
+	certPath, keyPath := filepath.Join(ca.dir, leafCertFile), filepath.Join(ca.dir, leafKeyFile)
+	if cert, key, err := loadKeyPair(certPath, keyPath); err == nil && ca.reusable(cert, hosts) {
+		return tls.Certificate{Certificate: [][]byte{cert.Raw, ca.cert.Raw}, PrivateKey: key, Leaf: cert}, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("tls: %w", err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: randomSerial(),
+		Subject:      pkix.Name{Organization: []string{"devserver"}, CommonName: hosts[0]},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(leafValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, key.Public(), ca.key)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("tls: %w", err)
+	}
+	if err := writeKeyPair(ca.dir, leafCertFile, leafKeyFile, der, key); err != nil {
+		return tls.Certificate{}, err
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der, ca.cert.Raw}, PrivateKey: key, Leaf: cert}, nil
+}
+
+// reusable reports whether cert was issued by ca, covers hosts and is valid
+// for a while.
+func (ca *localCA) reusable(cert *x509.Certificate, hosts []string) bool {
+	if cert.CheckSignatureFrom(ca.cert) != nil || time.Until(cert.NotAfter) < leafRenewal {
+		return false
+	}
+	for _, h := range hosts {
+		if cert.VerifyHostname(h) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// loadCertPool returns a pool with the PEM encoded certificates in file.
+func loadCertPool(file string) (*x509.CertPool, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, fmt.Errorf("%s: no certificates found", file)
+	}
+	return pool, nil
+}
+
+// tlsHosts returns the hosts the certificate of the listener on addr must
+// cover: localhost, the loopback addresses, the host of addr and extra.
+func tlsHosts(addr string, extra []string) []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if host, _, err := net.SplitHostPort(addr); err == nil && host != "" {
+		if ip := net.ParseIP(host); ip == nil || !ip.IsUnspecified() {
+			extra = append([]string{host}, extra...)
+		}
+	}
+	for _, h := range extra {
+		if !slices.Contains(hosts, h) {
+			hosts = append(hosts, h)
+		}
+	}
+	return hosts
+}
+
+// trustInstructions explains how to trust the CA certificate at path.
+func trustInstructions(path string) string {
+	return fmt.Sprintf(`Created a local certificate authority, trust it to use HTTPS without warnings:
+  macOS:   sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain %[1]q
+  Debian:  sudo cp %[1]q /usr/local/share/ca-certificates/devserver.crt && sudo update-ca-certificates
+  Fedora:  sudo cp %[1]q /etc/pki/ca-trust/source/anchors/devserver.pem && sudo update-ca-trust
+  Firefox: Settings > Privacy & Security > Certificates > View Certificates > Authorities > Import`, path)
+}
+
+// redirectToHTTPS redirects requests to the same URL on https using the
+// port of tlsAddr.
+func redirectToHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(r.Host); err == nil {
+			host = h
+		}
+		host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]" // IPv6
+		}
+		u := *r.URL
+		u.Scheme, u.Host = "https", host
+		http.Redirect(w, r, u.String(), http.StatusTemporaryRedirect)
+	})
+}
+
+func randomSerial() *big.Int {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		panic(err)
+	}
+	return serial
+}
+
+func loadKeyPair(certPath, keyPath string) (*x509.Certificate, crypto.Signer, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyPEM, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, _ := pem.Decode(certPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%s: no certificate found", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", certPath, err)
+	}
+	block, _ = pem.Decode(keyPEM)
+	if block == nil {
+		return nil, nil, fmt.Errorf("%s: no key found", keyPath)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: %w", keyPath, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s: unsupported key type", keyPath)
+	}
+	return cert, signer, nil
+}
+
+func writeKeyPair(dir, certFile, keyFile string, der []byte, key crypto.Signer) error {
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+
+	var certPEM, keyPEM bytes.Buffer
+	pem.Encode(&certPEM, &pem.Block{Type: "CERTIFICATE", Bytes: der})
+	pem.Encode(&keyPEM, &pem.Block{Type: "PRIVATE KEY", Bytes: keyDER})
+	if err := os.WriteFile(filepath.Join(dir, keyFile), keyPEM.Bytes(), 0o600); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, certFile), certPEM.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("tls: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLocalCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, created, err := loadCA(dir)
+	if err != nil || !created {
+		t.Fatalf("loadCA() = %v, %v", created, err)
+	}
+
+	hosts := []string{"localhost", "127.0.0.1", "app.test"}
+	cert, err := ca.Certificate(hosts)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	pool, err := loadCertPool(ca.CertPath())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, h := range hosts {
+		if _, err := cert.Leaf.Verify(x509.VerifyOptions{DNSName: h, Roots: pool}); err != nil {
+			t.Errorf("%s: %v", h, err)
+		}
+	}
+
+	// The CA and the certificate are reused.
+	ca2, created, err := loadCA(dir)
+	if err != nil || created {
+		t.Fatalf("loadCA() = %v, %v", created, err)
+	}
+	if !ca2.cert.Equal(ca.cert) {
+		t.Error("expected the CA to be reused")
+	}
+	cert2, err := ca2.Certificate(hosts[:2])
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !cert2.Leaf.Equal(cert.Leaf) {
+		t.Error("expected the certificate to be reused")
+	}
+
+	// A new certificate is issued for other hosts.
+	cert3, err := ca2.Certificate([]string{"localhost", "other.test"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if cert3.Leaf.Equal(cert.Leaf) || cert3.Leaf.VerifyHostname("other.test") != nil {
+		t.Errorf("expected a new certificate for other.test, got %v", cert3.Leaf.DNSNames)
+	}
+}
+
+func TestLoadCA_Incomplete(t *testing.T) {
+	dir := t.TempDir()
+	if _, _, err := loadCA(dir); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	certPEM, _ := os.ReadFile(filepath.Join(dir, caCertFile))
+	os.Remove(filepath.Join(dir, caKeyFile))
+
+	// The trusted certificate is not replaced.
+	if _, _, err := loadCA(dir); err == nil {
+		t.Fatal("expected an error but got none")
+	}
+	if b, _ := os.ReadFile(filepath.Join(dir, caCertFile)); !bytes.Equal(b, certPEM) {
+		t.Error("expected the CA certificate to be kept")
+	}
+}
+
+func TestTLSHosts(t *testing.T) {
+	tests := []struct {
+		addr  string
+		extra []string
+		want  []string
+	}{
+		{"127.0.0.1:8080", nil, []string{"localhost", "127.0.0.1", "::1"}},
+		{":8080", []string{"app.test"}, []string{"localhost", "127.0.0.1", "::1", "app.test"}},
+		{"0.0.0.0:8080", nil, []string{"localhost", "127.0.0.1", "::1"}},
+		{"192.168.1.5:8080", []string{"app.test", "localhost"}, []string{"localhost", "127.0.0.1", "::1", "192.168.1.5", "app.test"}},
+	}
+
+	for _, tt := range tests {
+		if got := tlsHosts(tt.addr, tt.extra); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("tlsHosts(%q, %q)\nwant: %q\ngot:  %q", tt.addr, tt.extra, tt.want, got)
+		}
+	}
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		tlsAddr string
+		host    string
+		want    string
+	}{
+		{"127.0.0.1:8443", "localhost:8080", "https://localhost:8443/a?b=1"},
+		{":443", "app.test", "https://app.test/a?b=1"},
+		{":443", "[::1]:80", "https://[::1]/a?b=1"},
+		{":8443", "[::1]", "https://[::1]:8443/a?b=1"},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/a?b=1", nil)
+		req.Host = tt.host
+		rec := httptest.NewRecorder()
+		redirectToHTTPS(tt.tlsAddr).ServeHTTP(rec, req)
+		if rec.Code != http.StatusTemporaryRedirect || rec.Header().Get("location") != tt.want {
+			t.Errorf("%s via %s\nwant: %s\ngot:  %d %s", tt.host, tt.tlsAddr, tt.want, rec.Code, rec.Header().Get("location"))
+		}
+	}
+}
@@ -0,0 +1,130 @@
+// toolbar.js renders the devserver status toolbar. It is served at
+// /_dev/toolbar.js and loaded by reload.js when the toolbar is enabled.
+// The toolbar lives in a shadow DOM so the styles of the page and the
+// toolbar cannot affect each other.
+
+const collapsedKey = "devserver-toolbar-collapsed";
+
+const colors = {
+	idle: "#2e7d32",
+	building: "#f9a825",
+	restarting: "#1565c0",
+	error: "#c62828",
+	stopping: "#9e9e9e",
+};
+
+const css = `
+:host { all: initial; }
+.bar {
+	position: fixed; right: 12px; bottom: 12px; z-index: 2147483647;
+	display: flex; align-items: center; gap: 8px;
+	padding: 6px 10px; border-radius: 16px;
+	background: rgba(33, 33, 33, .9); color: #fff;
+	font: 12px/1.4 system-ui, sans-serif;
+	box-shadow: 0 2px 8px rgba(0, 0, 0, .3);
+}
+.dot { width: 10px; height: 10px; border-radius: 50%; flex: none; cursor: pointer; }
+.details { display: flex; align-items: center; gap: 8px; }
+.collapsed .details { display: none; }
+.error { color: #ef9a9a; }
+button {
+	font: inherit; color: inherit; cursor: pointer;
+	background: rgba(255, 255, 255, .15); border: 0; border-radius: 8px; padding: 2px 8px;
+}
+button:hover { background: rgba(255, 255, 255, .3); }
+button:disabled { opacity: .5; cursor: default; }
+a { color: inherit; }
+`;
+
+const api = async (endpoint, body) => {
+	const resp = await fetch(`/_dev/api/${endpoint}`, {
+		method: "POST",
+		headers: { "content-type": "application/json" },
+		body: body === undefined ? undefined : JSON.stringify(body),
+	});
+	if (!resp.ok) {
+		throw new Error(`${endpoint}: ${resp.status} ${await resp.text()}`);
+	}
+	return resp.json();
+};
+
+// mount adds the toolbar to the page. Status updates are received as
+// "status" events on es.
+export const mount = async (es) => {
+	const host = document.createElement("div");
+	host.setAttribute("data-devserver", "");
+	const root = host.attachShadow({ mode: "closed" });
+	root.innerHTML = `
+		<style>${css}</style>
+		<div class="bar" part="bar">
+			<span class="dot" title="devserver"></span>
+			<span class="details">
+				<span class="state"></span>
+				<span class="build"></span>
+				<button data-action="rebuild">Rebuild</button>
+				<button data-action="restart">Restart</button>
+				<button data-action="live-reload"></button>
+				<a href="/_dev/requests" target="_blank">Requests</a>
+			</span>
+		</div>`;
+
+	const bar = root.querySelector(".bar");
+	const dot = root.querySelector(".dot");
+	const stateEl = root.querySelector(".state");
+	const buildEl = root.querySelector(".build");
+	const liveReloadBtn = root.querySelector("[data-action=live-reload]");
+	let status = null;
+
+	const setCollapsed = (collapsed) => {
+		bar.classList.toggle("collapsed", collapsed);
+		localStorage.setItem(collapsedKey, collapsed ? "1" : "");
+	};
+	setCollapsed(localStorage.getItem(collapsedKey) === "1");
+	dot.addEventListener("click", () => setCollapsed(!bar.classList.contains("collapsed")));
+
+	const render = (s) => {
+		status = s;
+		dot.style.background = colors[s.state] ?? "#9e9e9e";
+		dot.title = `devserver: ${s.state}`;
+		stateEl.textContent = s.error ? `${s.state}: ${s.error}` : s.state;
+		stateEl.classList.toggle("error", s.state === "error");
+		buildEl.textContent = s.lastBuildAt ? `last build ${(s.lastBuild / 1000).toFixed(1)}s${s.lastBuildOK ? "" : " (failed)"}` : "";
+		liveReloadBtn.textContent = `Live reload: ${s.liveReload ? "on" : "off"}`;
+		for (const btn of root.querySelectorAll("button[data-action=rebuild], button[data-action=restart]")) {
+			btn.disabled = s.state === "building" || s.state === "restarting";
+		}
+	};
+
+	root.addEventListener("click", async (e) => {
+		const action = e.target.closest("button")?.dataset.action;
+		if (!action) {
+			return;
+		}
+		try {
+			if (action === "live-reload") {
+				render(await api(action, { enabled: !status?.liveReload }));
+			} else {
+				await api(action);
+			}
+		} catch (err) {
+			console.error("devserver toolbar", err);
+		}
+	});
+
+	es.addEventListener("status", (e) => render(JSON.parse(e.data)));
+	es.addEventListener("shutdown", () => {
+		render({ ...status, state: "stopped", error: "" });
+		for (const btn of root.querySelectorAll("button")) {
+			btn.disabled = true;
+		}
+	});
+
+	document.body.appendChild(host);
+
+	try {
+		const resp = await fetch("/_dev/api/status");
+		render(await resp.json());
+	} catch (err) {
+		console.error("devserver toolbar", err);
+	}
+};
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// upstream is the address the server listens on, a TCP host:port or the
+// path of a Unix domain socket.
+type upstream struct {
+	Network string // tcp or unix
+	Addr    string
+}
+
+// parseUpstream parses host:port, http://host:port or unix:///path/to.sock.
+// Relative socket paths, e.g. unix://app.sock, are made absolute.
+func parseUpstream(s string) (upstream, error) {
+	if path, ok := strings.CutPrefix(s, "unix://"); ok {
+		if path == "" {
+			return upstream{}, fmt.Errorf("invalid upstream %q, socket path is missing", s)
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return upstream{}, err
+		}
+		return upstream{Network: "unix", Addr: abs}, nil
+	}
+
+	addr := strings.TrimPrefix(s, "http://")
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		return upstream{}, fmt.Errorf("invalid upstream %q, expected host:port or unix:///path/to.sock", s)
+	}
+	return upstream{Network: "tcp", Addr: addr}, nil
+}
+
+// URL returns the URL requests to the upstream are sent to. The host of
+// a socket upstream is a placeholder, the connection is made by Dial.
+func (u upstream) URL() *url.URL {
+	if u.Network == "unix" {
+		return &url.URL{Scheme: "http", Host: "localhost"}
+	}
+	return &url.URL{Scheme: "http", Host: u.Addr}
+}
+
+// Dial connects to the upstream.
+func (u upstream) Dial(ctx context.Context) (net.Conn, error) {
+	var d net.Dialer
+	return d.DialContext(ctx, u.Network, u.Addr)
+}
+
+func (u upstream) String() string {
+	if u.Network == "unix" {
+		return "unix://" + u.Addr
+	}
+	return u.Addr
+}
+
+// removeStaleSocket removes the socket file of a previous server so the
+// server can listen on it again. Files other than sockets are kept.
+func (u upstream) removeStaleSocket() error {
+	if u.Network != "unix" {
+		return nil
+	}
+	fi, err := os.Lstat(u.Addr)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&fs.ModeSocket == 0 {
+		return fmt.Errorf("%s exists and is not a socket", u.Addr)
+	}
+	return os.Remove(u.Addr)
+}
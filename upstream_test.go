@@ -0,0 +1,82 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseUpstream(t *testing.T) {
+	wd, _ := os.Getwd()
+	tests := []struct {
+		value   string
+		want    upstream
+		wantErr bool
+	}{
+		{"127.0.0.1:3000", upstream{"tcp", "127.0.0.1:3000"}, false},
+		{"http://localhost:3000", upstream{"tcp", "localhost:3000"}, false},
+		{"unix:///tmp/app.sock", upstream{"unix", "/tmp/app.sock"}, false},
+		{"unix://app.sock", upstream{"unix", filepath.Join(wd, "app.sock")}, false},
+		{"unix://", upstream{}, true},
+		{"localhost", upstream{}, true},
+		{"https://localhost:3000", upstream{}, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseUpstream(tt.value)
+		checkError(t, err, tt.wantErr)
+		if got != tt.want {
+			t.Errorf("parseUpstream(%q)\nwant: %+v\ngot:  %+v", tt.value, tt.want, got)
+		}
+	}
+}
+
+func TestUpstream_Unix(t *testing.T) {
+	up := upstream{Network: "unix", Addr: filepath.Join(t.TempDir(), "app.sock")}
+
+	// A socket left behind by a previous server is removed.
+	l, err := net.Listen("unix", up.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.(*net.UnixListener).SetUnlinkOnClose(false)
+	l.Close()
+	if err := up.removeStaleSocket(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Lstat(up.Addr); !os.IsNotExist(err) {
+		t.Errorf("expected the socket to be removed, got %v", err)
+	}
+
+	l, err = net.Listen("unix", up.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.URL.Path)
+	}))
+	srv.Listener = l
+	srv.Start()
+	defer srv.Close()
+
+	client := &http.Client{Transport: newUpstreamTransport(up, false)}
+	resp, err := client.Get(up.URL().String() + "/a")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "hello /a" {
+		t.Errorf("unexpected response %q", body)
+	}
+
+	// Other files are not removed.
+	file := upstream{Network: "unix", Addr: filepath.Join(t.TempDir(), "file")}
+	os.WriteFile(file.Addr, nil, 0o644)
+	if err := file.removeStaleSocket(); err == nil {
+		t.Error("expected an error but got none")
+	}
+}
@@ -2,18 +2,24 @@ package main
 
 import (
 	"bufio"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"net/http"
 	"os/exec"
 	"path"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 )
 
-func watchFiles(exts []string, f func(fsEventBatch)) {
+// watchFiles calls f with the changes of the files with the given
+// extensions in the current directory. Errors are written to logs. It returns
+// when ctx is cancelled.
+func watchFiles(ctx context.Context, logs *logMux, exts []string, f func(fsEventBatch)) {
 	args := []string{
 		"--batch-marker=+",
 		"--no-defer",
@@ -23,17 +29,20 @@ func watchFiles(exts []string, f func(fsEventBatch)) {
 	// Watch the current directory
 	args = append(args, ".")
 
-	cmd := exec.Command("fswatch", args...)
+	cmd := exec.CommandContext(ctx, "fswatch", args...)
+	stderr := logs.Writer(sourceWatch, streamStderr)
+	defer stderr.Close()
+	cmd.Stderr = stderr
 	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		fmt.Printf("watch error: %v\n", err)
+		logs.Print(sourceWatch, streamStderr, fmt.Sprintf("watch error: %v", err))
 		return
 	}
 
 	infof("Watching files: %v", exts)
 	go func() {
-		if err := cmd.Run(); err != nil {
-			fmt.Printf("watch error: %v\n", err)
+		if err := cmd.Run(); err != nil && ctx.Err() == nil {
+			logs.Print(sourceWatch, streamStderr, fmt.Sprintf("watch error: %v", err))
 		}
 	}()
 
@@ -60,7 +69,13 @@ type fsEvent struct {
 	File   string
 	Ext    string
 	Events []string
-	t      time.Time
+	// Stylesheets lists the URLs of the stylesheets affected by the change,
+	// see cssResolver.
+	Stylesheets []string `json:",omitempty"`
+	// Modules lists the URLs of the JavaScript modules accepting the
+	// change, see moduleGraph.
+	Modules []string `json:",omitempty"`
+	t       time.Time
 }
 
 func parseEvent(s string) fsEvent {
@@ -73,8 +88,15 @@ func parseEvent(s string) fsEvent {
 	}
 }
 
+// Default settings of the /_dev event stream.
+const (
+	heartbeatInterval = 15 * time.Second
+	retryInterval     = 1 * time.Second
+)
+
 type watchHandler struct {
-	bc *Broadcaster[fsEventBatch]
+	stream    *eventStream
+	heartbeat time.Duration
 }
 
 func (h *watchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -83,34 +105,90 @@ func (h *watchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Only reconnecting clients get the events they missed. A new client,
+	// e.g. a page that was just reloaded, must not see the events that
+	// happened before it connected.
+	var (
+		lastID uint64
+		resume bool
+	)
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid Last-Event-ID", http.StatusBadRequest)
+			return
+		}
+		lastID, resume = id, true
+	}
+
+	// Clients can limit the events they receive, e.g. /_dev?events=change
+	var types []string
+	for _, v := range r.URL.Query()["events"] {
+		for t := range strings.SplitSeq(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				types = append(types, t)
+			}
+		}
+	}
+
 	c := http.NewResponseController(w)
 
 	w.Header().Set("content-type", "text/event-stream")
 	w.Header().Set("cache-control", "no-cache")
 	w.WriteHeader(http.StatusOK)
 
+	fmt.Fprintf(w, "retry: %d\n\n", retryInterval.Milliseconds())
+
+	replay, ch, remove := h.stream.Subscribe(lastID, types...)
+	defer remove()
+	if !resume {
+		replay = nil
+	}
+
+	for _, ev := range replay {
+		writeEvent(w, ev)
+	}
 	c.Flush()
 
+	heartbeat := h.heartbeat
+	if heartbeat == 0 {
+		heartbeat = heartbeatInterval
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
 	ctx := r.Context()
-	ch, remove := h.bc.AddListener()
 	for {
 		select {
 		case <-ctx.Done():
-			remove()
 			return
-		case events := <-ch:
-			data, err := json.Marshal(map[string]any{
-				"events": events,
-				"time":   time.Now(),
-			})
-			if err != nil {
-				log.Printf("watchHandler: json encode error: %v", err)
-				continue
+		case <-ticker.C:
+			// Comment lines are ignored by EventSource but keep idle
+			// connections from being cut by proxies.
+			fmt.Fprint(w, ": heartbeat\n\n")
+			c.Flush()
+		case ev, ok := <-ch:
+			if !ok {
+				// The client fell too far behind or devserver is
+				// shutting down. Dropping the connection makes the client
+				// reconnect and replay the missed events.
+				return
 			}
-
-			fmt.Fprintln(w, "event: change")
-			fmt.Fprintf(w, "data: %s\n\n", data)
+			writeEvent(w, ev)
 			c.Flush()
 		}
 	}
 }
+
+// writeEvent writes ev in the server-sent events format to w.
+func writeEvent(w io.Writer, ev devEvent) {
+	data, err := json.Marshal(ev.Data)
+	if err != nil {
+		log.Printf("watchHandler: json encode error: %v", err)
+		return
+	}
+
+	fmt.Fprintf(w, "id: %d\n", ev.ID)
+	fmt.Fprintf(w, "event: %s\n", ev.Type)
+	fmt.Fprintf(w, "data: %s\n\n", data)
+}